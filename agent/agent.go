@@ -0,0 +1,349 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// agent source name
+const (
+	PostgresSource = "postgres"
+	MemorySource   = "memory"
+)
+
+// agent error
+var (
+	ErrUnknownAgent  = errcode.New(errcode.UnknownAgent, http.StatusForbidden, "unknown agent")
+	ErrAgentDisabled = errcode.New(errcode.AgentDisabled, http.StatusForbidden, "agent disabled")
+	ErrAgentCurrency = errcode.New(errcode.CurrencyNotAllowed, http.StatusBadRequest, "currency not allowed for agent")
+	ErrAgentLimit    = errcode.New(errcode.OverAgentLimit, http.StatusBadRequest, "amount over agent limit")
+	ErrAgentInvalid  = errcode.New(errcode.InvalidAgent, http.StatusBadRequest, "agent need positive id, name, status active or disabled, supported currencies, secret with api key")
+	ErrAgentNotFound = errcode.New(errcode.AgentNotFound, http.StatusNotFound, "agent not found")
+	ErrAgentExists   = errcode.New(errcode.AgentExists, http.StatusConflict, "agent id or api key exists")
+	ErrAgentInUse    = errcode.New(errcode.AgentInUse, http.StatusConflict, "agent has users")
+	ErrAgentNoWallet = errcode.New(errcode.AgentNoWallet, http.StatusBadRequest, "agent has no wallet user")
+)
+
+// Source agent storage
+type Source interface {
+	// Load every agent
+	Load() ([]modules.Agent, error)
+	// Create add agent, ErrAgentExists if id or api key taken
+	Create(a *modules.Agent) error
+	// Update replace agent of same id, ErrAgentNotFound if none
+	Update(a *modules.Agent) error
+	// Delete remove agent, ErrAgentInUse while it has users
+	Delete(id int) error
+}
+
+var (
+	agentConfig = &config.Default().Agent
+	source      = newSource(agentConfig)
+	mu          sync.RWMutex
+	agents      = make(map[int]*modules.Agent)
+	byKey       = make(map[string]*modules.Agent)
+)
+
+// InitWithConfig ...
+//
+// source is built here and only read after, handlers use it without lock
+func InitWithConfig(c *config.AgentConfig) {
+	agentConfig = c
+	source = newSource(c)
+}
+
+func newSource(c *config.AgentConfig) Source {
+	switch c.Source {
+	case MemorySource:
+		return &memorySource{}
+	default:
+		return &pgSource{}
+	}
+}
+
+func getSource() Source {
+	return source
+}
+
+// Reload replace agent table with source content
+func Reload() error {
+	list, err := getSource().Load()
+	if err != nil {
+		return err
+	}
+
+	t := make(map[int]*modules.Agent)
+	k := make(map[string]*modules.Agent)
+	for i := range list {
+		a := &list[i]
+		t[a.ID] = a
+		if a.APIKey != "" {
+			k[a.APIKey] = a
+		}
+	}
+
+	mu.Lock()
+	agents, byKey = t, k
+	mu.Unlock()
+
+	return nil
+}
+
+// ReloadWorker reload agent table every interval, so agent changed on
+// other instance take effect here
+func ReloadWorker(ctx context.Context) {
+	log.Print("start agent reload worker...")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Print("stop agent reload worker...")
+			return
+		case <-time.After(agentConfig.ReloadInterval):
+			if err := Reload(); err != nil {
+				log.Println("agent reload:", err)
+			}
+		}
+	}
+}
+
+// Active agent is known and not disabled. Nothing checked when not
+// enforced
+func Active(id string) error {
+	if !agentConfig.Enforce {
+		return nil
+	}
+
+	a := find(id)
+	switch {
+	case a == nil:
+		return ErrUnknownAgent
+	case a.Status != modules.AgentActive:
+		return ErrAgentDisabled
+	}
+
+	return nil
+}
+
+// Check agent is active and may run op of amount touching every one of
+// currencies, both sides of a converted op. empty currency is skipped.
+// Nothing checked when not enforced
+func Check(id string, amount money.Amount, currencies ...string) error {
+	if !agentConfig.Enforce {
+		return nil
+	}
+
+	a := find(id)
+	switch {
+	case a == nil:
+		return ErrUnknownAgent
+	case a.Status != modules.AgentActive:
+		return ErrAgentDisabled
+	}
+
+	for _, c := range currencies {
+		if c != "" && !a.Allow(c) {
+			return ErrAgentCurrency
+		}
+	}
+	if a.MaxAmount > 0 && amount > a.MaxAmount {
+		return ErrAgentLimit
+	}
+
+	return nil
+}
+
+// WalletUser user whose wallets are agent wallets, checked whether
+// enforced or not since top up has no other debit side
+func WalletUser(id string) (string, error) {
+	a := find(id)
+	switch {
+	case a == nil:
+		return "", ErrUnknownAgent
+	case a.WalletUser == "":
+		return "", ErrAgentNoWallet
+	}
+
+	return a.WalletUser, nil
+}
+
+func find(id string) *modules.Agent {
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return nil
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return agents[i]
+}
+
+// Get agent of id
+func Get(id int) (*modules.Agent, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	a, ok := agents[id]
+	if !ok {
+		return nil, ErrAgentNotFound
+	}
+	c := *a
+	return &c, nil
+}
+
+// List every agent by id
+func List() []modules.Agent {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]modules.Agent, 0, len(agents))
+	for _, a := range agents {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list
+}
+
+// Create save new agent, active if status not set
+func Create(p *modules.PostAgent) (*modules.Agent, error) {
+	a := build(p)
+	if err := validate(a); err != nil {
+		return nil, err
+	}
+
+	a.CreateAt = time.Now()
+	a.UpdateAt = a.CreateAt
+	if err := getSource().Create(a); err != nil {
+		return nil, err
+	}
+
+	put(a)
+	return a, nil
+}
+
+// Update replace agent of id, empty secret keep the old one
+func Update(id int, p *modules.PostAgent) (*modules.Agent, error) {
+	old, err := Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	a := build(p)
+	a.ID = id
+	if a.Secret == "" {
+		a.Secret = old.Secret
+	}
+	if err := validate(a); err != nil {
+		return nil, err
+	}
+
+	a.CreateAt = old.CreateAt
+	a.UpdateAt = time.Now()
+	if err := getSource().Update(a); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	delete(byKey, old.APIKey)
+	mu.Unlock()
+	put(a)
+
+	return a, nil
+}
+
+// Delete remove agent of id, only agent without users
+func Delete(id int) error {
+	a, err := Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := getSource().Delete(id); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	delete(agents, id)
+	delete(byKey, a.APIKey)
+
+	return nil
+}
+
+// Ensure create agent of id with name if not exist yet
+func Ensure(id int, name string) error {
+	if _, err := Get(id); err == nil {
+		return nil
+	}
+
+	_, err := Create(&modules.PostAgent{ID: id, Name: name})
+	if err == ErrAgentExists {
+		return Reload()
+	}
+	return err
+}
+
+func build(p *modules.PostAgent) *modules.Agent {
+	a := &modules.Agent{
+		ID:          p.ID,
+		Name:        p.Name,
+		Status:      p.Status,
+		Currencies:  modules.CurrencyList(p.Currencies),
+		APIKey:      p.APIKey,
+		Secret:      p.Secret,
+		CallbackURL: p.CallbackURL,
+		MaxAmount:   p.MaxAmount,
+		WalletUser:  p.WalletUser,
+	}
+	if a.Status == "" {
+		a.Status = modules.AgentActive
+	}
+	return a
+}
+
+func validate(a *modules.Agent) error {
+	if a.ID <= 0 || a.Name == "" || a.MaxAmount < 0 {
+		return ErrAgentInvalid
+	}
+	if a.Status != modules.AgentActive && a.Status != modules.AgentDisabled {
+		return ErrAgentInvalid
+	}
+	if a.APIKey != "" && a.Secret == "" {
+		return ErrAgentInvalid
+	}
+	for _, c := range a.Currencies {
+		if _, err := money.CurrencyDecimals(c); err != nil {
+			return ErrAgentInvalid
+		}
+	}
+
+	// api key of other agent, source may not know if it is memory
+	mu.RLock()
+	defer mu.RUnlock()
+	if o, ok := byKey[a.APIKey]; ok && a.APIKey != "" && o.ID != a.ID {
+		return ErrAgentExists
+	}
+
+	return nil
+}
+
+func put(a *modules.Agent) {
+	c := *a
+
+	mu.Lock()
+	defer mu.Unlock()
+	agents[c.ID] = &c
+	if c.APIKey != "" {
+		byKey[c.APIKey] = &c
+	}
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// useMemory memory source with empty agent table, enforced
+func useMemory(t *testing.T) {
+	t.Helper()
+
+	InitWithConfig(&config.AgentConfig{Source: MemorySource, ReloadInterval: time.Minute, Enforce: true})
+	mu.Lock()
+	agents, byKey = make(map[int]*modules.Agent), make(map[string]*modules.Agent)
+	mu.Unlock()
+}
+
+// TestConcurrentUse handlers create and check agents at once, run with -race
+func TestConcurrentUse(t *testing.T) {
+	useMemory(t)
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if _, err := Create(&modules.PostAgent{ID: id, Name: "agent"}); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := Get(id); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(List()); n != 8 {
+		t.Errorf("agents %d, want 8", n)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	useMemory(t)
+	for _, p := range []*modules.PostAgent{
+		{ID: 1, Name: "twd only", Currencies: []string{"TWD"}, MaxAmount: money.FromInt(100)},
+		{ID: 2, Name: "disabled", Status: modules.AgentDisabled},
+	} {
+		if _, err := Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		amount     money.Amount
+		currencies []string
+		wantErr    error
+	}{
+		{"ok", "1", money.FromInt(100), []string{"TWD", ""}, nil},
+		{"unknown", "3", money.FromInt(1), []string{"TWD"}, ErrUnknownAgent},
+		{"disabled", "2", money.FromInt(1), []string{"TWD"}, ErrAgentDisabled},
+		{"currency not allowed", "1", money.FromInt(1), []string{"USD"}, ErrAgentCurrency},
+		{"credit currency not allowed", "1", money.FromInt(1), []string{"TWD", "USD"}, ErrAgentCurrency},
+		{"over limit", "1", money.FromInt(101), []string{"TWD"}, ErrAgentLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Check(tt.id, tt.amount, tt.currencies...); err != tt.wantErr {
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
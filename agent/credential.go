@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"strconv"
+
+	"github.com/kyos0109/test-wallet/auth"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+// credentialStore api key of agent table, key no agent has is looked up
+// in fallback
+type credentialStore struct {
+	fallback auth.CredentialStore
+}
+
+// Credentials credential store of agent table over fallback, api key of
+// disabled agent is rejected
+func Credentials(fallback auth.CredentialStore) auth.CredentialStore {
+	return &credentialStore{fallback: fallback}
+}
+
+// Lookup credential of agent owning api key, agent id as credential agent.
+// key of disabled agent is ErrAgentDisabled, key no agent has go to
+// fallback, nil without one
+func (s *credentialStore) Lookup(apiKey string) (*auth.Credential, error) {
+	mu.RLock()
+	a := byKey[apiKey]
+	mu.RUnlock()
+
+	if a == nil {
+		if s.fallback == nil {
+			return nil, nil
+		}
+		return s.fallback.Lookup(apiKey)
+	}
+	if a.Status != modules.AgentActive {
+		return nil, ErrAgentDisabled
+	}
+
+	return &auth.Credential{Agent: strconv.Itoa(a.ID), Secret: a.Secret}, nil
+}
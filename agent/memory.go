@@ -0,0 +1,30 @@
+package agent
+
+import "github.com/kyos0109/test-wallet/modules"
+
+// memorySource keep nothing, the loaded table is the only copy
+type memorySource struct{}
+
+// Load ...
+func (s *memorySource) Load() ([]modules.Agent, error) {
+	return List(), nil
+}
+
+// Create ...
+func (s *memorySource) Create(a *modules.Agent) error {
+	if _, err := Get(a.ID); err == nil {
+		return ErrAgentExists
+	}
+	return nil
+}
+
+// Update ...
+func (s *memorySource) Update(a *modules.Agent) error {
+	_, err := Get(a.ID)
+	return err
+}
+
+// Delete ...
+func (s *memorySource) Delete(id int) error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+type pgSource struct{}
+
+// Load ...
+func (s *pgSource) Load() ([]modules.Agent, error) {
+	list := []modules.Agent{}
+	r := database.GetDBInstance().Conn().Order("id").Find(&list)
+	return list, r.Error
+}
+
+// Create ...
+func (s *pgSource) Create(a *modules.Agent) error {
+	err := database.GetDBInstance().Conn().Create(a).Error
+	if database.UniqueViolation(err) {
+		return ErrAgentExists
+	}
+	return err
+}
+
+// Update ...
+func (s *pgSource) Update(a *modules.Agent) error {
+	r := database.GetDBInstance().Conn().Model(&modules.Agent{}).Where("id = ?", a.ID).
+		Select("name", "status", "currencies", "api_key", "secret", "callback_url", "max_amount", "wallet_user", "update_at").
+		Updates(a)
+	if database.UniqueViolation(r.Error) {
+		return ErrAgentExists
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		return ErrAgentNotFound
+	}
+	return nil
+}
+
+// Delete ...
+func (s *pgSource) Delete(id int) error {
+	r := database.GetDBInstance().Conn().Delete(&modules.Agent{}, id)
+	if database.ForeignKeyViolation(r.Error) {
+		return ErrAgentInUse
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		return ErrAgentNotFound
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kyos0109/test-wallet/errcode"
+)
+
+// HeaderAdminToken request header of admin call
+const HeaderAdminToken = "X-Admin-Token"
+
+// ErrAdminToken ...
+var ErrAdminToken = errcode.New(errcode.AdminToken, http.StatusUnauthorized, "admin token invalid")
+
+// AdminConfigured true when auth enabled with an admin token, admin api
+// is not mounted otherwise
+func AdminConfigured() bool {
+	return authConfig.Enabled && authConfig.AdminToken != ""
+}
+
+// AdminMiddleware check admin token, fail closed when admin auth not
+// configured
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := c.GetHeader(HeaderAdminToken)
+		if !AdminConfigured() || subtle.ConstantTimeCompare([]byte(t), []byte(authConfig.AdminToken)) != 1 {
+			c.AbortWithStatusJSON(errcode.StatusOf(ErrAdminToken), errcode.Fail(ErrAdminToken))
+			return
+		}
+
+		c.Next()
+	}
+}
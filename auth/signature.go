@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/errcode"
+	kredis "github.com/kyos0109/test-wallet/redis"
+)
+
+// request header of signed agent call
+const (
+	HeaderAPIKey    = "X-Api-Key"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderSignature = "X-Signature"
+
+	// AgentKey gin context key of verified agent
+	AgentKey = "agent"
+)
+
+// signature error
+var (
+	ErrMissingSignature = errcode.New(errcode.MissingSignature, http.StatusUnauthorized, "missing signature header")
+	ErrUnknownAPIKey    = errcode.New(errcode.UnknownAPIKey, http.StatusUnauthorized, "unknown api key")
+	ErrBadTimestamp     = errcode.New(errcode.BadTimestamp, http.StatusUnauthorized, "timestamp out of window")
+	ErrBadSignature     = errcode.New(errcode.BadSignature, http.StatusUnauthorized, "signature mismatch")
+	ErrReplay           = errcode.New(errcode.SignatureReplay, http.StatusUnauthorized, "signature replay")
+	ErrAgentMismatch    = errcode.New(errcode.AgentMismatch, http.StatusForbidden, "agent not match api key")
+)
+
+// Credential api key owner
+type Credential struct {
+	Agent  string
+	Secret string
+}
+
+// CredentialStore look up credential by api key, nil if not found
+type CredentialStore interface {
+	Lookup(apiKey string) (*Credential, error)
+}
+
+type configCredentials map[string]*Credential
+
+// Lookup ...
+func (c configCredentials) Lookup(apiKey string) (*Credential, error) {
+	return c[apiKey], nil
+}
+
+var (
+	authConfig                  = &config.Default().Auth
+	credentials CredentialStore = configCredentials{}
+	seen                        = &memoryReplay{signs: make(map[string]time.Time)}
+)
+
+// InitWithConfig ...
+func InitWithConfig(c *config.AuthConfig) {
+	authConfig = c
+
+	creds := make(configCredentials)
+	for _, a := range c.Agents {
+		creds[a.APIKey] = &Credential{Agent: a.Agent, Secret: a.Secret}
+	}
+	credentials = creds
+}
+
+// SetCredentialStore replace config credentials
+func SetCredentialStore(s CredentialStore) {
+	credentials = s
+}
+
+// Credentials credential store in use
+func Credentials() CredentialStore {
+	return credentials
+}
+
+// Sign hex hmac-sha256 of timestamp, method, path and body joined by ".",
+// body of GET request is its raw query string. method and path are signed
+// so body signed for one api can not be sent to other
+func Sign(secret, timestamp, method, path string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	for _, s := range []string{timestamp, method, path} {
+		m.Write([]byte(s))
+		m.Write([]byte("."))
+	}
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// SignatureMiddleware verify agent signature before controller
+func SignatureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authConfig.Enabled {
+			c.Next()
+			return
+		}
+
+		agent, err := verify(c)
+		if err != nil {
+			c.AbortWithStatusJSON(errcode.StatusOf(err), errcode.Fail(err))
+			return
+		}
+
+		c.Set(AgentKey, agent)
+		c.Next()
+	}
+}
+
+// CheckAgent post agent must be the signed one when signing enabled
+func CheckAgent(c *gin.Context, agent string) error {
+	if !authConfig.Enabled {
+		return nil
+	}
+	if c.GetString(AgentKey) != agent {
+		return ErrAgentMismatch
+	}
+	return nil
+}
+
+func verify(c *gin.Context) (string, error) {
+	key := c.GetHeader(HeaderAPIKey)
+	ts := c.GetHeader(HeaderTimestamp)
+	sign := c.GetHeader(HeaderSignature)
+	if key == "" || ts == "" || sign == "" {
+		return "", ErrMissingSignature
+	}
+
+	cred, err := credentials.Lookup(key)
+	if err != nil {
+		return "", err
+	}
+	if cred == nil {
+		return "", ErrUnknownAPIKey
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", ErrBadTimestamp
+	}
+	if d := time.Since(time.Unix(sec, 0)); d > authConfig.ReplayWindow || d < -authConfig.ReplayWindow {
+		return "", ErrBadTimestamp
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if c.Request.Method == http.MethodGet {
+		body = []byte(c.Request.URL.RawQuery)
+	}
+
+	if !hmac.Equal([]byte(sign), []byte(Sign(cred.Secret, ts, c.Request.Method, c.Request.URL.Path, body))) {
+		return "", ErrBadSignature
+	}
+
+	// same signature can not be used twice inside window
+	ok, err := markSign(sign)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrReplay
+	}
+
+	return cred.Agent, nil
+}
+
+func markSign(sign string) (bool, error) {
+	ttl := 2 * authConfig.ReplayWindow
+
+	if authConfig.ReplayBackend == "memory" {
+		return seen.mark(sign, ttl), nil
+	}
+
+	return kredis.GetRedisClientInstance().SetNX(kredis.RedisSignPerfix+kredis.RedisDelimiter+sign, ttl)
+}
+
+type memoryReplay struct {
+	mu    sync.Mutex
+	signs map[string]time.Time
+}
+
+func (m *memoryReplay) mark(sign string, ttl time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for s, t := range m.signs {
+		if now.After(t) {
+			delete(m.signs, s)
+		}
+	}
+
+	if _, ok := m.signs[sign]; ok {
+		return false
+	}
+	m.signs[sign] = now.Add(ttl)
+	return true
+}
@@ -0,0 +1,376 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kyos0109/test-wallet/money"
+	"gopkg.in/yaml.v2"
+)
+
+// Config service setting, load from yaml or json file
+type Config struct {
+	Backend  string         `yaml:"backend"`
+	HTTP     HTTPConfig     `yaml:"http"`
+	Database DatabaseConfig `yaml:"database"`
+	Redis    RedisConfig    `yaml:"redis"`
+	Wallet   WalletConfig   `yaml:"wallet"`
+	Session  SessionConfig  `yaml:"session"`
+	Auth     AuthConfig     `yaml:"auth"`
+	FX       FXConfig       `yaml:"fx"`
+	Agent    AgentConfig    `yaml:"agent"`
+}
+
+// HTTPConfig ...
+type HTTPConfig struct {
+	Addr            string        `yaml:"addr"`
+	PprofAddr       string        `yaml:"pprofAddr"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// DatabaseConfig ...
+type DatabaseConfig struct {
+	DSN             string        `yaml:"dsn"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime"`
+	Migrate         bool          `yaml:"migrate"`
+	Isolation       string        `yaml:"isolation"`
+	TxRetries       int           `yaml:"txRetries"`
+}
+
+// RedisConfig ...
+type RedisConfig struct {
+	Addr              string        `yaml:"addr"`
+	Password          string        `yaml:"password"`
+	DB                int           `yaml:"db"`
+	MaxRetries        int           `yaml:"maxRetries"`
+	DialTimeout       time.Duration `yaml:"dialTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	PoolSize          int           `yaml:"poolSize"`
+	LockTTL           time.Duration `yaml:"lockTTL"`
+	LockTimeout       time.Duration `yaml:"lockTimeout"`
+	LockRetryInterval time.Duration `yaml:"lockRetryInterval"`
+	LockRenewInterval time.Duration `yaml:"lockRenewInterval"`
+}
+
+// WalletConfig ...
+type WalletConfig struct {
+	RequestIDTTL       time.Duration `yaml:"requestIDTTL"`
+	ExpiryInterval     time.Duration `yaml:"expiryInterval"`
+	HoldTTL            time.Duration `yaml:"holdTTL"`
+	HoldMaxTTL         time.Duration `yaml:"holdMaxTTL"`
+	HoldExpiryInterval time.Duration `yaml:"holdExpiryInterval"`
+	Currency           string        `yaml:"currency"`
+	SyncEnabled        bool          `yaml:"syncEnabled"`
+	SyncInterval       time.Duration `yaml:"syncInterval"`
+	SyncBatch          int           `yaml:"syncBatch"`
+	SyncMaxAttempts    int           `yaml:"syncMaxAttempts"`
+	ReconcileWindow    time.Duration `yaml:"reconcileWindow"`
+	// AgentRequestIDTTL request id ttl by agent, other agent use RequestIDTTL
+	AgentRequestIDTTL map[string]time.Duration `yaml:"agentRequestIDTTL"`
+}
+
+// SessionConfig player token
+type SessionConfig struct {
+	Backend string        `yaml:"backend"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// AuthConfig agent request signing
+type AuthConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	ReplayWindow  time.Duration     `yaml:"replayWindow"`
+	ReplayBackend string            `yaml:"replayBackend"`
+	AdminToken    string            `yaml:"adminToken"`
+	Agents        []AgentCredential `yaml:"agents"`
+}
+
+// AgentCredential api key and secret of one agent
+type AgentCredential struct {
+	Agent  string `yaml:"agent"`
+	APIKey string `yaml:"apiKey"`
+	Secret string `yaml:"secret"`
+}
+
+// FXConfig exchange rate table, source postgres, file or memory
+type FXConfig struct {
+	Source         string        `yaml:"source"`
+	File           string        `yaml:"file"`
+	BaseCurrency   string        `yaml:"baseCurrency"`
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+// AgentConfig agent table, source postgres or memory. Enforce reject op of
+// unknown or disabled agent
+type AgentConfig struct {
+	Source         string        `yaml:"source"`
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+	Enforce        bool          `yaml:"enforce"`
+}
+
+// Default setting for local run
+func Default() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:            ":8080",
+			PprofAddr:       "0.0.0.0:6060",
+			ShutdownTimeout: 5 * time.Second,
+		},
+		Database: DatabaseConfig{
+			DSN:             "user=happy dbname=wallte port=5432 sslmode=disable TimeZone=Asia/Taipei",
+			MaxIdleConns:    50,
+			MaxOpenConns:    2000,
+			ConnMaxIdleTime: time.Hour,
+			Isolation:       "read committed",
+			TxRetries:       3,
+		},
+		Redis: RedisConfig{
+			Addr:              "127.0.0.1:6379",
+			MaxRetries:        3,
+			DialTimeout:       5 * time.Second,
+			WriteTimeout:      3 * time.Second,
+			PoolSize:          200,
+			LockTTL:           30 * time.Second,
+			LockTimeout:       30 * time.Second,
+			LockRetryInterval: 50 * time.Millisecond,
+			LockRenewInterval: 10 * time.Second,
+		},
+		Wallet: WalletConfig{
+			RequestIDTTL:       60 * time.Second,
+			ExpiryInterval:     60 * time.Second,
+			HoldTTL:            10 * time.Minute,
+			HoldMaxTTL:         time.Hour,
+			HoldExpiryInterval: 5 * time.Second,
+			Currency:           "TWD",
+			SyncEnabled:        true,
+			SyncInterval:       time.Second,
+			SyncBatch:          500,
+			SyncMaxAttempts:    5,
+			ReconcileWindow:    24 * time.Hour,
+		},
+		Session: SessionConfig{
+			Backend: "redis",
+			TTL:     24 * time.Hour,
+		},
+		Auth: AuthConfig{
+			Enabled:       true,
+			ReplayWindow:  5 * time.Minute,
+			ReplayBackend: "redis",
+		},
+		FX: FXConfig{
+			Source:         "postgres",
+			BaseCurrency:   "USD",
+			ReloadInterval: time.Minute,
+		},
+		Agent: AgentConfig{
+			Source:         "postgres",
+			ReloadInterval: 30 * time.Second,
+			Enforce:        true,
+		},
+	}
+}
+
+// Load default, then file (if path not empty), then env, then validate
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// yaml is json superset, one decoder for both
+		if err := yaml.UnmarshalStrict(b, c); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) loadEnv() error {
+	strs := map[string]*string{
+		"WALLET_BACKEND":             &c.Backend,
+		"WALLET_HTTP_ADDR":           &c.HTTP.Addr,
+		"WALLET_PPROF_ADDR":          &c.HTTP.PprofAddr,
+		"WALLET_DATABASE_DSN":        &c.Database.DSN,
+		"WALLET_DATABASE_ISOLATION":  &c.Database.Isolation,
+		"WALLET_REDIS_ADDR":          &c.Redis.Addr,
+		"WALLET_REDIS_PASSWORD":      &c.Redis.Password,
+		"WALLET_SESSION_BACKEND":     &c.Session.Backend,
+		"WALLET_AUTH_REPLAY_BACKEND": &c.Auth.ReplayBackend,
+		"WALLET_CURRENCY":            &c.Wallet.Currency,
+		"WALLET_AUTH_ADMIN_TOKEN":    &c.Auth.AdminToken,
+		"WALLET_FX_SOURCE":           &c.FX.Source,
+		"WALLET_FX_FILE":             &c.FX.File,
+		"WALLET_FX_BASE_CURRENCY":    &c.FX.BaseCurrency,
+		"WALLET_AGENT_SOURCE":        &c.Agent.Source,
+	}
+	for k, p := range strs {
+		if v, ok := os.LookupEnv(k); ok {
+			*p = v
+		}
+	}
+
+	ints := map[string]*int{
+		"WALLET_DATABASE_MAX_IDLE_CONNS": &c.Database.MaxIdleConns,
+		"WALLET_DATABASE_MAX_OPEN_CONNS": &c.Database.MaxOpenConns,
+		"WALLET_DATABASE_TX_RETRIES":     &c.Database.TxRetries,
+		"WALLET_REDIS_DB":                &c.Redis.DB,
+		"WALLET_REDIS_POOL_SIZE":         &c.Redis.PoolSize,
+		"WALLET_SYNC_BATCH":              &c.Wallet.SyncBatch,
+		"WALLET_SYNC_MAX_ATTEMPTS":       &c.Wallet.SyncMaxAttempts,
+	}
+	for k, p := range ints {
+		if v, ok := os.LookupEnv(k); ok {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("env %s: %v", k, err)
+			}
+			*p = i
+		}
+	}
+
+	durs := map[string]*time.Duration{
+		"WALLET_HTTP_SHUTDOWN_TIMEOUT": &c.HTTP.ShutdownTimeout,
+		"WALLET_REDIS_LOCK_TTL":        &c.Redis.LockTTL,
+		"WALLET_REDIS_LOCK_TIMEOUT":    &c.Redis.LockTimeout,
+		"WALLET_REDIS_LOCK_RETRY":      &c.Redis.LockRetryInterval,
+		"WALLET_REDIS_LOCK_RENEW":      &c.Redis.LockRenewInterval,
+		"WALLET_REQUEST_ID_TTL":        &c.Wallet.RequestIDTTL,
+		"WALLET_EXPIRY_INTERVAL":       &c.Wallet.ExpiryInterval,
+		"WALLET_HOLD_TTL":              &c.Wallet.HoldTTL,
+		"WALLET_HOLD_MAX_TTL":          &c.Wallet.HoldMaxTTL,
+		"WALLET_HOLD_EXPIRY_INTERVAL":  &c.Wallet.HoldExpiryInterval,
+		"WALLET_SESSION_TTL":           &c.Session.TTL,
+		"WALLET_AUTH_REPLAY_WINDOW":    &c.Auth.ReplayWindow,
+		"WALLET_FX_RELOAD_INTERVAL":    &c.FX.ReloadInterval,
+		"WALLET_SYNC_INTERVAL":         &c.Wallet.SyncInterval,
+		"WALLET_RECONCILE_WINDOW":      &c.Wallet.ReconcileWindow,
+		"WALLET_AGENT_RELOAD_INTERVAL": &c.Agent.ReloadInterval,
+	}
+
+	for k, p := range durs {
+		if v, ok := os.LookupEnv(k); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("env %s: %v", k, err)
+			}
+			*p = d
+		}
+	}
+
+	bools := map[string]*bool{
+		"WALLET_AUTH_ENABLED":     &c.Auth.Enabled,
+		"WALLET_SYNC_ENABLED":     &c.Wallet.SyncEnabled,
+		"WALLET_DATABASE_MIGRATE": &c.Database.Migrate,
+		"WALLET_AGENT_ENFORCE":    &c.Agent.Enforce,
+	}
+	for k, p := range bools {
+		if v, ok := os.LookupEnv(k); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("env %s: %v", k, err)
+			}
+			*p = b
+		}
+	}
+
+	return nil
+}
+
+// Validate check required and range
+func (c *Config) Validate() error {
+	switch {
+	case c.Backend != "" && c.Backend != "memory":
+		return errors.New("config backend must be empty or memory")
+	case c.HTTP.Addr == "":
+		return errors.New("config http.addr required")
+	case c.Database.DSN == "":
+		return errors.New("config database.dsn required")
+	case c.Database.MaxOpenConns <= 0 || c.Database.MaxIdleConns < 0:
+		return errors.New("config database conns must be positive")
+	case c.Database.Isolation != "read committed" && c.Database.Isolation != "repeatable read" && c.Database.Isolation != "serializable":
+		return errors.New("config database.isolation must be read committed, repeatable read or serializable")
+	case c.Database.TxRetries < 0:
+		return errors.New("config database.txRetries must not be negative")
+	case c.Redis.Addr == "":
+		return errors.New("config redis.addr required")
+	case c.Redis.PoolSize <= 0:
+		return errors.New("config redis.poolSize must be positive")
+	case c.Redis.LockTTL < time.Millisecond || c.Redis.LockTimeout <= 0 || c.Redis.LockRetryInterval <= 0:
+		return errors.New("config redis lock durations must be positive, lockTTL at least 1ms")
+	case c.Redis.LockRenewInterval <= 0 || c.Redis.LockRenewInterval >= c.Redis.LockTTL:
+		return errors.New("config redis.lockRenewInterval must be positive and under lockTTL")
+	case c.Wallet.RequestIDTTL < time.Second:
+		return errors.New("config wallet.requestIDTTL must be at least 1s")
+	case c.Wallet.ExpiryInterval <= 0:
+		return errors.New("config wallet.expiryInterval must be positive")
+	case c.Wallet.HoldTTL <= 0 || c.Wallet.HoldMaxTTL < c.Wallet.HoldTTL:
+		return errors.New("config wallet.holdTTL must be positive and not over holdMaxTTL")
+	case c.Wallet.HoldExpiryInterval <= 0:
+		return errors.New("config wallet.holdExpiryInterval must be positive")
+	case c.Wallet.SyncInterval <= 0 || c.Wallet.SyncBatch <= 0 || c.Wallet.SyncMaxAttempts <= 0:
+		return errors.New("config wallet sync interval, batch and max attempts must be positive")
+	case c.Wallet.ReconcileWindow <= 0:
+		return errors.New("config wallet.reconcileWindow must be positive")
+	case c.Session.Backend != "redis" && c.Session.Backend != "postgres" && c.Session.Backend != "memory":
+		return errors.New("config session.backend must be redis, postgres or memory")
+	case c.Session.TTL <= 0:
+		return errors.New("config session.ttl must be positive")
+	case c.Auth.ReplayWindow <= 0:
+		return errors.New("config auth.replayWindow must be positive")
+	case c.Auth.ReplayBackend != "redis" && c.Auth.ReplayBackend != "memory":
+		return errors.New("config auth.replayBackend must be redis or memory")
+	case c.FX.Source != "postgres" && c.FX.Source != "file" && c.FX.Source != "memory":
+		return errors.New("config fx.source must be postgres, file or memory")
+	case c.FX.Source == "file" && c.FX.File == "":
+		return errors.New("config fx.file required for file source")
+	case c.FX.ReloadInterval <= 0:
+		return errors.New("config fx.reloadInterval must be positive")
+	case c.Agent.Source != "postgres" && c.Agent.Source != "memory":
+		return errors.New("config agent.source must be postgres or memory")
+	case c.Agent.ReloadInterval <= 0:
+		return errors.New("config agent.reloadInterval must be positive")
+	}
+
+	if _, err := money.CurrencyDecimals(c.Wallet.Currency); err != nil {
+		return errors.New("config wallet.currency not support: " + c.Wallet.Currency)
+	}
+	if _, err := money.CurrencyDecimals(c.FX.BaseCurrency); err != nil {
+		return errors.New("config fx.baseCurrency not support: " + c.FX.BaseCurrency)
+	}
+
+	for agent, ttl := range c.Wallet.AgentRequestIDTTL {
+		if ttl < time.Second {
+			return errors.New("config wallet.agentRequestIDTTL must be at least 1s: " + agent)
+		}
+	}
+
+	keys := make(map[string]bool)
+	for _, a := range c.Auth.Agents {
+		if a.Agent == "" || a.APIKey == "" || a.Secret == "" {
+			return errors.New("config auth.agents need agent, apiKey and secret")
+		}
+		if keys[a.APIKey] {
+			return errors.New("config auth.agents apiKey repeat: " + a.APIKey)
+		}
+		keys[a.APIKey] = true
+	}
+
+	return nil
+}
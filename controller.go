@@ -1,14 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,8 +18,15 @@ import (
 
 	"github.com/go-playground/validator/v10"
 
+	"github.com/kyos0109/test-wallet/agent"
+	"github.com/kyos0109/test-wallet/auth"
 	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/fx"
+	"github.com/kyos0109/test-wallet/ledger"
 	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	"github.com/kyos0109/test-wallet/session"
 	"github.com/kyos0109/test-wallet/wallet"
 
 	kredis "github.com/kyos0109/test-wallet/redis"
@@ -33,7 +41,14 @@ var (
 	validate *validator.Validate
 )
 
-// WsWallte ...
+// bad request of balance query
+var (
+	errAgentRequired = errcode.Invalid(errors.New("agent required"))
+	errUsersRequired = errcode.Invalid(errors.New("user or users required"))
+)
+
+// WsWallte wallet websocket of signed agent, each message must be of
+// that agent
 func WsWallte(c *gin.Context) {
 	ws, err := upGrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -63,18 +78,37 @@ func WsWallte(c *gin.Context) {
 				}
 			}
 		case websocket.BinaryMessage:
-			switch true {
-			case bytes.Index(message, []byte(kredis.PostDeductCmd)) > 0:
+			var a modules.PostWsAction
+			if err := json.Unmarshal(message, &a); err != nil {
+				wsReply(ws, mt, nil, errcode.Invalid(err))
+				continue
+			}
+
+			switch a.Action {
+			case kredis.PostCaptureCmd:
+				rd.PostData = &modules.PostDatav2{Detail: &modules.PostCapturev2{}}
+			case kredis.PostReleaseCmd:
+				rd.PostData = &modules.PostDatav2{Detail: &modules.PostReleasev2{}}
+			case kredis.PostHoldCmd:
+				rd.PostData = &modules.PostDatav2{Detail: &modules.PostHoldv2{}}
+			case kredis.PostTransferCmd:
+				rd.PostData = &modules.PostDatav2{Detail: &modules.PostTransferv2{}}
+			case kredis.PostRollbackCmd:
+				rd.PostData = &modules.PostDatav2{Detail: &modules.PostRollbackv2{}}
+			case kredis.PostDeductCmd:
 				rd.PostData = &modules.PostDatav2{Detail: &modules.PostDeductv2{}}
-			case bytes.Index(message, []byte(kredis.PostStoreCmd)) > 0:
+			case kredis.PostStoreCmd:
 				rd.PostData = &modules.PostDatav2{Detail: &modules.PostStorev2{}}
+			case kredis.PostBalanceCmd:
+				wsBalance(c, ws, mt, message)
+				continue
 			default:
-				ws.WriteMessage(mt, []byte("Not Allow Action"))
-				break
+				wsReply(ws, mt, nil, wallet.ErrInterface)
+				continue
 			}
 
 			if err := json.Unmarshal([]byte(message), &rd.PostData); err != nil {
-				ws.WriteMessage(mt, []byte(err.Error()))
+				wsReply(ws, mt, nil, errcode.Invalid(err))
 				break
 			}
 
@@ -88,26 +122,24 @@ func WsWallte(c *gin.Context) {
 				}
 
 				vErrs := err.(validator.ValidationErrors)
-				ws.WriteMessage(mt, []byte(vErrs.Error()))
+				wsReply(ws, mt, nil, errcode.Invalid(vErrs))
 				break
 			}
 
-			_, err = wallet.Entry(&rd)
-			if err != nil {
-				ws.WriteMessage(mt, []byte(err.Error()))
+			if err := auth.CheckAgent(c, rd.PostData.Agent); err != nil {
+				wsReply(ws, mt, nil, err)
 				break
 			}
 
-			json, err := json.Marshal(&rd)
-			if err != nil {
-				ws.WriteMessage(mt, []byte(err.Error()))
-				break
-			}
+			rd.PostData.ClientIP = c.ClientIP()
 
-			err = ws.WriteMessage(mt, json)
+			op, _, err := wallet.Process(wallet.GetStore(wallet.RedisBackend), rd.PostData)
 			if err != nil {
+				wsReply(ws, mt, nil, err)
 				break
 			}
+
+			wsReply(ws, mt, op, nil)
 		default:
 			err := ws.WriteMessage(mt, []byte(defaultMessage))
 			if err != nil {
@@ -117,24 +149,77 @@ func WsWallte(c *gin.Context) {
 	}
 }
 
+// wsReply write answer envelope of data, or of err if not nil
+func wsReply(ws *websocket.Conn, mt int, data interface{}, err error) error {
+	r := errcode.OK(data)
+	if err != nil {
+		r = errcode.Fail(err)
+	}
+
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(mt, j)
+}
+
+// wsBalance answer balance command of websocket
+func wsBalance(c *gin.Context, ws *websocket.Conn, mt int, message []byte) {
+	var p modules.PostBalance
+	if err := json.Unmarshal(message, &p); err != nil {
+		wsReply(ws, mt, nil, errcode.Invalid(err))
+		return
+	}
+	if err := validator.New().Struct(&p); err != nil {
+		wsReply(ws, mt, nil, errcode.Invalid(err))
+		return
+	}
+
+	if err := auth.CheckAgent(c, p.Agent); err != nil {
+		wsReply(ws, mt, nil, err)
+		return
+	}
+
+	if err := session.Validate(p.Token, p.Agent, p.User); err != nil {
+		wsReply(ws, mt, nil, err)
+		return
+	}
+
+	b, err := wallet.QueryBalance(wallet.GetStore(wallet.RedisBackend), p.Agent, p.User, p.Currency)
+	wsReply(ws, mt, b, err)
+}
+
 // CreateRedisData fake data
 func CreateRedisData(c *gin.Context) {
-	r := kredis.GetRedisClientInstance()
-
 	accountStart := 201
 	accountEnd := 501
 
+	if err := agent.Ensure(100, "agent 100"); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	if m := wallet.Memory(); m != nil {
+		for i := accountStart; i < accountEnd; i++ {
+			m.SetBalance("100", strconv.Itoa(i), wallet.DefaultCurrency(), money.FromInt(100000))
+		}
+		c.JSON(http.StatusOK, errcode.OK(nil))
+		return
+	}
+
+	r := kredis.GetRedisClientInstance()
+
 	hashMap := make(map[string]interface{})
-	hashMap[kredis.RedisHashWalletKey] = 100000
+	hashMap[kredis.RedisHashWalletKey] = int64(money.FromInt(100000))
 	hashMap[kredis.RedisHashlastChangeKey] = time.Now()
 	hashMap[kredis.RedisHashlastGameKey] = 1
 
 	for i := accountStart; i < accountEnd; i++ {
-		u := wallet.BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, "100", strconv.Itoa(i))
+		u := wallet.BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, "100", strconv.Itoa(i), wallet.DefaultCurrency())
 		r.UserWalletHMSet(&modules.RedisData{UserKey: u, HashMap: hashMap})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"succes": true})
+	c.JSON(http.StatusOK, errcode.OK(nil))
 }
 
 var happy = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890")
@@ -154,6 +239,19 @@ func CreateDBData(c *gin.Context) {
 	accountEnd := 501
 	agnetID := 100
 
+	if err := agent.Ensure(agnetID, "agent 100"); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	if m := wallet.Memory(); m != nil {
+		for i := accountStart; i < accountEnd; i++ {
+			m.SetBalance(strconv.Itoa(agnetID), strconv.Itoa(i), wallet.DefaultCurrency(), money.FromInt(100000))
+		}
+		c.JSON(http.StatusOK, errcode.OK(nil))
+		return
+	}
+
 	fakeUsers := []modules.User{}
 
 	for i := accountStart; i < accountEnd; i++ {
@@ -171,49 +269,243 @@ func CreateDBData(c *gin.Context) {
 	}
 
 	for _, v := range users {
-		wallets = append(wallets, modules.Wallet{UserID: v.ID, Amount: 100000.00, Currency: "TWD", UpdateAt: time.Now()})
+		wallets = append(wallets, modules.Wallet{UserID: v.ID, Amount: money.FromInt(100000), Currency: wallet.DefaultCurrency(), UpdateAt: time.Now()})
 	}
 	pg.CreateFakceWallet(wallets)
 
-	c.JSON(http.StatusOK, gin.H{"succes": true})
+	// opening entry, so ledger balance match fake wallet
+	for i, v := range users {
+		w := wallets[i]
+		a := ledger.Wallet(strconv.Itoa(v.AgentID), strconv.Itoa(v.ID), w.Currency)
+		if err := ledger.Post(pg.Conn(), nil, modules.WalletOpening, ledger.Opening(a, w.Currency, w.Amount)); err != nil {
+			log.Println(err)
+		}
+	}
+
+	c.JSON(http.StatusOK, errcode.OK(nil))
 }
 
 // DeductWalletController ...
 func DeductWalletController(c *gin.Context) {
-	var rd modules.RedisData
+	walletOpController(c, wallet.RedisBackend, &modules.PostDeductv2{})
+}
 
-	rd.PostData = &modules.PostDatav2{Detail: &modules.PostDeductv2{}}
+// StoreWalletController ...
+func StoreWalletController(c *gin.Context) {
+	walletOpController(c, wallet.RedisBackend, &modules.PostStorev2{})
+}
 
-	if err := c.ShouldBindJSON(&rd.PostData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// RollbackWalletController ...
+func RollbackWalletController(c *gin.Context) {
+	walletOpController(c, wallet.RedisBackend, &modules.PostRollbackv2{})
+}
+
+// TransferWalletController ...
+func TransferWalletController(c *gin.Context) {
+	walletOpController(c, wallet.RedisBackend, &modules.PostTransferv2{})
+}
+
+// HoldWalletController ...
+func HoldWalletController(c *gin.Context) {
+	walletOpController(c, wallet.RedisBackend, &modules.PostHoldv2{})
+}
+
+// CaptureWalletController ...
+func CaptureWalletController(c *gin.Context) {
+	walletOpController(c, wallet.RedisBackend, &modules.PostCapturev2{})
+}
+
+// ReleaseWalletController ...
+func ReleaseWalletController(c *gin.Context) {
+	walletOpController(c, wallet.RedisBackend, &modules.PostReleasev2{})
+}
+
+// BalanceController ...
+func BalanceController(c *gin.Context) {
+	balanceController(c, wallet.RedisBackend)
+}
+
+// BalanceControllerDB ...
+func BalanceControllerDB(c *gin.Context) {
+	balanceController(c, wallet.PostgresBackend)
+}
+
+// balanceController balance of user, or of comma separated users as list.
+// query: agent, user or users, currency (default wallet currency)
+func balanceController(c *gin.Context, backend string) {
+	agent := c.Query("agent")
+	if agent == "" {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errAgentRequired))
 		return
 	}
 
-	status, err := wallet.Entry(&rd)
+	if err := auth.CheckAgent(c, agent); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	s := wallet.GetStore(backend)
+	currency := c.Query("currency")
+
+	if users, ok := c.GetQuery("users"); ok {
+		list, err := wallet.QueryBalances(s, agent, currency, strings.Split(users, ","))
+		if err != nil {
+			c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, errcode.OK(list))
+		return
+	}
+
+	user := c.Query("user")
+	if user == "" {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errUsersRequired))
+		return
+	}
+
+	b, err := wallet.QueryBalance(s, agent, user, currency)
 	if err != nil {
-		c.JSON(status, gin.H{"succes": false, "data": err.Error()})
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"succes": true, "data": &rd})
+	c.JSON(http.StatusOK, errcode.OK(b))
 }
 
-// StoreWalletController ...
-func StoreWalletController(c *gin.Context) {
-	var rd modules.RedisData
+// OrdersController ...
+func OrdersController(c *gin.Context) {
+	ordersController(c, wallet.RedisBackend, true)
+}
 
-	rd.PostData = &modules.PostDatav2{Detail: &modules.PostStorev2{}}
+// OrdersControllerDB ...
+func OrdersControllerDB(c *gin.Context) {
+	ordersController(c, wallet.PostgresBackend, true)
+}
 
-	if err := c.ShouldBindJSON(&rd.PostData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// AdminOrdersController support search any agent, on postgres
+func AdminOrdersController(c *gin.Context) {
+	ordersController(c, wallet.PostgresBackend, false)
+}
+
+// ordersController search orders of agent, page by next cursor
+func ordersController(c *gin.Context, backend string, checkAgent bool) {
+	var p modules.QueryOrders
+	if err := c.ShouldBindQuery(&p); err != nil {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
 		return
 	}
 
-	status, err := wallet.Entry(&rd)
+	if checkAgent {
+		if err := auth.CheckAgent(c, p.Agent); err != nil {
+			c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+			return
+		}
+	}
+
+	page, err := wallet.SearchOrders(wallet.GetStore(backend), &wallet.OrderQuery{
+		Agent:     p.Agent,
+		User:      p.User,
+		Currency:  p.Currency,
+		From:      p.From,
+		To:        p.To,
+		OpType:    p.OpType,
+		GameID:    p.GameID,
+		Status:    modules.OrderStatus(p.Status),
+		RequestID: p.RequestID,
+		Cursor:    p.Cursor,
+		Limit:     p.Limit,
+	})
 	if err != nil {
-		c.JSON(status, gin.H{"succes": false, "data": err.Error()})
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"succes": true, "data": &rd})
+	c.JSON(http.StatusOK, errcode.OK(page))
+}
+
+// ExportOrdersController ...
+func ExportOrdersController(c *gin.Context) {
+	exportOrdersController(c, wallet.RedisBackend, true)
+}
+
+// ExportOrdersControllerDB ...
+func ExportOrdersControllerDB(c *gin.Context) {
+	exportOrdersController(c, wallet.PostgresBackend, true)
+}
+
+// AdminExportOrdersController support export any agent, on postgres
+func AdminExportOrdersController(c *gin.Context) {
+	exportOrdersController(c, wallet.PostgresBackend, false)
+}
+
+// exportOrdersController stream orders of agent in time range as csv or
+// ndjson. Error after first row can only cut the stream, it is logged
+func exportOrdersController(c *gin.Context, backend string, checkAgent bool) {
+	var p modules.QueryExport
+	if err := c.ShouldBindQuery(&p); err != nil {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
+		return
+	}
+
+	if checkAgent {
+		if err := auth.CheckAgent(c, p.Agent); err != nil {
+			c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+			return
+		}
+	}
+
+	if p.Format == "" {
+		p.Format = wallet.ExportCSV
+	}
+	q := &wallet.OrderQuery{
+		Agent:    p.Agent,
+		User:     p.User,
+		Currency: p.Currency,
+		From:     p.From,
+		To:       p.To,
+		OpType:   p.OpType,
+		Status:   modules.OrderStatus(p.Status),
+	}
+	if err := wallet.CheckExport(q, p.Format); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	name := fmt.Sprintf("orders-%s-%s-%s.%s", p.Agent, p.From.UTC().Format("20060102"), p.To.UTC().Format("20060102"), p.Format)
+	c.Header("Content-Type", wallet.ExportContentType(p.Format))
+	c.Header("Content-Disposition", `attachment; filename="`+name+`"`)
+	c.Status(http.StatusOK)
+
+	if n, err := wallet.Export(wallet.GetStore(backend), q, p.Format, c.Writer); err != nil {
+		log.Println("export orders of agent", p.Agent, "stop after", n, "rows:", err)
+	}
+}
+
+// walletOpController bind post data and run it on backend
+func walletOpController(c *gin.Context, backend string, detail interface{}) {
+	var p modules.PostDatav2
+
+	p.ClientIP = c.ClientIP()
+	p.Detail = detail
+
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
+		return
+	}
+
+	if err := auth.CheckAgent(c, p.Agent); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	op, status, err := wallet.Process(wallet.GetStore(backend), &p)
+	if err != nil {
+		c.JSON(status, errcode.Fail(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, errcode.OK(op))
 }
 
 func produce(ch chan<- string, p *modules.PostData) {
@@ -226,7 +518,7 @@ func produce(ch chan<- string, p *modules.PostData) {
 func chinSelectCaseFunc(c *gin.Context) {
 	var p modules.PostData
 	if err := c.ShouldBindJSON(&p); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
 		return
 	}
 
@@ -253,13 +545,13 @@ func chinSelectCaseFunc(c *gin.Context) {
 
 		fmt.Printf("Read from channel %#v and received %s\n", chans[chosen], value.String())
 	}
-	c.JSON(http.StatusOK, gin.H{"succes": true, "data": p})
+	c.JSON(http.StatusOK, errcode.OK(p))
 }
 
 func chinSelectFunc(c *gin.Context) {
 	var p modules.PostData
 	if err := c.ShouldBindJSON(&p); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
 		return
 	}
 
@@ -268,47 +560,203 @@ func chinSelectFunc(c *gin.Context) {
 	// fmt.Printf("%#v \n", ch)
 	<-ch
 
-	c.JSON(http.StatusOK, gin.H{"succes": true, "data": p})
+	c.JSON(http.StatusOK, errcode.OK(p))
 }
 
 // DeductWalletControllerDB ...
 func DeductWalletControllerDB(c *gin.Context) {
-	var p modules.PostDatav2
+	walletOpController(c, wallet.PostgresBackend, &modules.PostDeductv2{})
+}
 
-	p.ClientIP = c.ClientIP()
-	p.Detail = &modules.PostDeductv2{}
+// StoreWalletControllerDB ...
+func StoreWalletControllerDB(c *gin.Context) {
+	walletOpController(c, wallet.PostgresBackend, &modules.PostStorev2{})
+}
+
+// RollbackWalletControllerDB ...
+func RollbackWalletControllerDB(c *gin.Context) {
+	walletOpController(c, wallet.PostgresBackend, &modules.PostRollbackv2{})
+}
+
+// TransferWalletControllerDB ...
+func TransferWalletControllerDB(c *gin.Context) {
+	walletOpController(c, wallet.PostgresBackend, &modules.PostTransferv2{})
+}
+
+// HoldWalletControllerDB ...
+func HoldWalletControllerDB(c *gin.Context) {
+	walletOpController(c, wallet.PostgresBackend, &modules.PostHoldv2{})
+}
+
+// CaptureWalletControllerDB ...
+func CaptureWalletControllerDB(c *gin.Context) {
+	walletOpController(c, wallet.PostgresBackend, &modules.PostCapturev2{})
+}
+
+// ReleaseWalletControllerDB ...
+func ReleaseWalletControllerDB(c *gin.Context) {
+	walletOpController(c, wallet.PostgresBackend, &modules.PostReleasev2{})
+}
+
+// IssueTokenController ...
+func IssueTokenController(c *gin.Context) {
+	var p modules.PostIssueToken
 
 	if err := c.ShouldBindJSON(&p); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
+		return
+	}
+
+	if err := auth.CheckAgent(c, p.Agent); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	if err := agent.Active(p.Agent); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
 		return
 	}
 
-	status, err := wallet.EntryDB(&p)
+	t, err := session.Issue(p.Agent, p.User)
 	if err != nil {
-		c.JSON(status, gin.H{"succes": false, "data": err.Error()})
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"succes": true, "data": &p})
+	c.JSON(http.StatusOK, errcode.OK(t))
 }
 
-// StoreWalletControllerDB ...
-func StoreWalletControllerDB(c *gin.Context) {
-	var p modules.PostDatav2
+// RevokeTokenController ...
+func RevokeTokenController(c *gin.Context) {
+	var p modules.PostRevokeToken
 
-	p.ClientIP = c.ClientIP()
-	p.Detail = &modules.PostStorev2{}
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
+		return
+	}
+
+	t, err := session.Get(p.Token)
+	if err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	if err := auth.CheckAgent(c, t.Agent); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	if err := session.Revoke(p.Token); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, errcode.OK(nil))
+}
+
+// ErrorCodesController catalogue of api error code
+func ErrorCodesController(c *gin.Context) {
+	c.JSON(http.StatusOK, errcode.OK(errcode.Catalogue()))
+}
+
+// FXRatesController ...
+func FXRatesController(c *gin.Context) {
+	c.JSON(http.StatusOK, errcode.OK(fx.Rates()))
+}
+
+// UpdateFXRateController ...
+func UpdateFXRateController(c *gin.Context) {
+	var r modules.FXRate
+
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
+		return
+	}
+
+	if err := fx.Update(&r); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, errcode.OK(r))
+}
+
+// AgentsController ...
+func AgentsController(c *gin.Context) {
+	c.JSON(http.StatusOK, errcode.OK(agent.List()))
+}
+
+// AgentController ...
+func AgentController(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errcode.Fail(agent.ErrAgentNotFound))
+		return
+	}
+
+	a, err := agent.Get(id)
+	if err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, errcode.OK(a))
+}
+
+// CreateAgentController ...
+func CreateAgentController(c *gin.Context) {
+	var p modules.PostAgent
+
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
+		return
+	}
+
+	a, err := agent.Create(&p)
+	if err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, errcode.OK(a))
+}
+
+// UpdateAgentController ...
+func UpdateAgentController(c *gin.Context) {
+	var p modules.PostAgent
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errcode.Fail(agent.ErrAgentNotFound))
+		return
+	}
 
 	if err := c.ShouldBindJSON(&p); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, errcode.Fail(errcode.Invalid(err)))
+		return
+	}
+
+	a, err := agent.Update(id, &p)
+	if err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
 		return
 	}
 
-	status, err := wallet.EntryDB(&p)
+	c.JSON(http.StatusOK, errcode.OK(a))
+}
+
+// DeleteAgentController ...
+func DeleteAgentController(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(status, gin.H{"succes": false, "data": err.Error()})
+		c.JSON(http.StatusNotFound, errcode.Fail(agent.ErrAgentNotFound))
+		return
+	}
+
+	if err := agent.Delete(id); err != nil {
+		c.JSON(errcode.StatusOf(err), errcode.Fail(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"succes": true, "data": &p})
+	c.JSON(http.StatusOK, errcode.OK(nil))
 }
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Migration one schema version, Up and Down each run in one transaction
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus known or applied migration, AppliedAt nil if not applied
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt"`
+}
+
+// migrationLock advisory lock key, one instance migrate at a time
+const migrationLock = 20201101
+
+const createMigrationTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// LatestVersion newest migration version of this build
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// MigrateUp apply migrations not applied yet up to version, 0 for latest.
+// return count applied
+func (db *DBConn) MigrateUp(version int) (int, error) {
+	if version == 0 {
+		version = LatestVersion()
+	}
+
+	n := 0
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+
+		ok, err := db.migrate(m, true)
+		if err != nil {
+			return n, fmt.Errorf("migrate up %d %s: %v", m.Version, m.Name, err)
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// MigrateDown revert applied migrations above version, newest first,
+// negative version revert newest applied one only. return count reverted
+func (db *DBConn) MigrateDown(version int) (int, error) {
+	if version < 0 {
+		list, err := db.MigrationStatus()
+		if err != nil {
+			return 0, err
+		}
+
+		// version of second newest applied
+		version = 0
+		newest := 0
+		for _, s := range list {
+			if s.AppliedAt != nil {
+				version, newest = newest, s.Version
+			}
+		}
+	}
+
+	n := 0
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= version {
+			break
+		}
+
+		ok, err := db.migrate(m, false)
+		if err != nil {
+			return n, fmt.Errorf("migrate down %d %s: %v", m.Version, m.Name, err)
+		}
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// MigrationStatus every known migration and applied one unknown to this
+// build, by version
+func (db *DBConn) MigrationStatus() ([]MigrationStatus, error) {
+	sqlDB, err := db.conn.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sqlDB.ExecContext(ctx, createMigrationTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := sqlDB.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		s := MigrationStatus{AppliedAt: &time.Time{}}
+		if err := rows.Scan(&s.Version, &s.Name, s.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := []MigrationStatus{}
+	for _, m := range migrations {
+		s, ok := applied[m.Version]
+		if !ok {
+			s = MigrationStatus{Version: m.Version, Name: m.Name}
+		}
+		delete(applied, m.Version)
+		list = append(list, s)
+	}
+
+	// applied by other build
+	for _, s := range applied {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	return list, nil
+}
+
+// migrate run one step in a transaction holding migration lock, false if
+// version already in that state
+func (db *DBConn) migrate(m *Migration, up bool) (bool, error) {
+	sqlDB, err := db.conn.DB()
+	if err != nil {
+		return false, err
+	}
+
+	// plain sql tx, migration text hold many statements and can not be
+	// prepared
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	done, err := migrateTx(tx, m, up)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if !done {
+		return false, tx.Rollback()
+	}
+
+	return true, tx.Commit()
+}
+
+func migrateTx(tx *sql.Tx, m *Migration, up bool) (bool, error) {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, createMigrationTable); err != nil {
+		return false, err
+	}
+
+	applied := false
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&applied)
+	if err != nil {
+		return false, err
+	}
+	if applied == up {
+		return false, nil
+	}
+
+	if up {
+		if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+			return false, err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+		return err == nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	return err == nil, err
+}
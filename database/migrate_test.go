@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kyos0109/test-wallet/config"
+)
+
+// testDSNEnv scratch postgres database for tests needing one, tests skip
+// without it. its schema is dropped and made again, never point at real data.
+// database and wallet tests share it, run them with go test -p 1
+const testDSNEnv = "WALLET_TEST_DATABASE_DSN"
+
+// testDB connection to database of testDSNEnv, skip test without it
+func testDB(t *testing.T) *DBConn {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv, "not set")
+	}
+
+	pctx := context.Background()
+	InitWithCtx(&pctx)
+	c := config.Default().Database
+	c.DSN = dsn
+	InitWithConfig(&c)
+
+	return GetDBInstance()
+}
+
+// schemaQuery tables, columns, indexes and constraints of current schema,
+// one line each. migration book table and extensions left out, column
+// position too, drop and add again move it
+const schemaQuery = `SELECT 'column ' || table_name || '.' || column_name || ' ' || data_type || ' ' || is_nullable || ' ' || coalesce(column_default, '')
+FROM information_schema.columns WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'
+UNION ALL
+SELECT 'index ' || indexdef FROM pg_indexes WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'
+UNION ALL
+SELECT 'constraint ' || conrelid::regclass || ' ' || conname || ' ' || pg_get_constraintdef(oid)
+FROM pg_constraint WHERE connamespace = current_schema()::regnamespace AND conrelid <> 'schema_migrations'::regclass
+UNION ALL
+SELECT 'sequence ' || sequence_name FROM information_schema.sequences WHERE sequence_schema = current_schema()
+ORDER BY 1`
+
+func schemaOf(t *testing.T, db *DBConn) []string {
+	t.Helper()
+
+	sqlDB, err := db.conn.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := sqlDB.QueryContext(ctx, schemaQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	list := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, s)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// schemaDiff lines only in got, and only in want
+func schemaDiff(got, want []string) (extra, missing []string) {
+	in := func(list []string, s string) bool {
+		for _, v := range list {
+			if v == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, s := range got {
+		if !in(want, s) {
+			extra = append(extra, s)
+		}
+	}
+	for _, s := range want {
+		if !in(got, s) {
+			missing = append(missing, s)
+		}
+	}
+	return extra, missing
+}
+
+// TestMigrateRoundTrip every version go up, down and up again, down must give
+// back schema of version before and up again same schema as first time
+func TestMigrateRoundTrip(t *testing.T) {
+	db := testDB(t)
+
+	if _, err := db.MigrateDown(0); err != nil {
+		t.Fatal(err)
+	}
+	prev := schemaOf(t, db)
+
+	for _, m := range migrations {
+		if n, err := db.MigrateUp(m.Version); err != nil || n != 1 {
+			t.Fatalf("up %d applied %d: %v", m.Version, n, err)
+		}
+		up := schemaOf(t, db)
+		if reflect.DeepEqual(up, prev) {
+			t.Errorf("up %d changed no schema", m.Version)
+		}
+
+		if n, err := db.MigrateDown(m.Version - 1); err != nil || n != 1 {
+			t.Fatalf("down %d reverted %d: %v", m.Version, n, err)
+		}
+		if got := schemaOf(t, db); !reflect.DeepEqual(got, prev) {
+			extra, missing := schemaDiff(got, prev)
+			t.Errorf("down %d left %q, lost %q", m.Version, extra, missing)
+		}
+
+		if n, err := db.MigrateUp(m.Version); err != nil || n != 1 {
+			t.Fatalf("up %d again applied %d: %v", m.Version, n, err)
+		}
+		if got := schemaOf(t, db); !reflect.DeepEqual(got, up) {
+			extra, missing := schemaDiff(got, up)
+			t.Errorf("up %d again made %q, lost %q", m.Version, extra, missing)
+		}
+
+		prev = up
+	}
+
+	if n, err := db.MigrateDown(0); err != nil || n != len(migrations) {
+		t.Fatalf("down all reverted %d: %v", n, err)
+	}
+}
@@ -0,0 +1,310 @@
+package database
+
+// migrations schema of modules, ascending version, never edit an applied
+// one, add a new version instead. create use IF NOT EXISTS so schema made
+// out of band before migrations is adopted by version 1
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "create users wallets orders api_request_ids",
+		Up: `
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+	id bigserial PRIMARY KEY,
+	agent_id bigint NOT NULL DEFAULT 0,
+	name text NOT NULL DEFAULT '',
+	status boolean NOT NULL DEFAULT true,
+	update_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_users_agent_id ON users (agent_id);
+
+CREATE TABLE IF NOT EXISTS wallets (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	amount numeric(20,4) NOT NULL DEFAULT 0,
+	held numeric(20,4) NOT NULL DEFAULT 0,
+	currency varchar(3) NOT NULL,
+	update_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT fk_wallets_user FOREIGN KEY (user_id) REFERENCES users (id),
+	CONSTRAINT chk_wallets_amount CHECK (amount >= 0),
+	CONSTRAINT chk_wallets_held CHECK (held >= 0)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wallets_user_currency ON wallets (user_id, currency);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+	user_id bigint NOT NULL,
+	wallet_id bigint NOT NULL,
+	game_id bigint NOT NULL DEFAULT 0,
+	op_type text NOT NULL,
+	request_id text NOT NULL DEFAULT '',
+	ref_order_id uuid,
+	before_amount numeric(20,4) NOT NULL DEFAULT 0,
+	after_amount numeric(20,4) NOT NULL DEFAULT 0,
+	status text NOT NULL,
+	comment text NOT NULL DEFAULT '',
+	create_at timestamptz NOT NULL DEFAULT now(),
+	update_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT fk_orders_user FOREIGN KEY (user_id) REFERENCES users (id),
+	CONSTRAINT fk_orders_wallet FOREIGN KEY (wallet_id) REFERENCES wallets (id)
+);
+CREATE INDEX IF NOT EXISTS idx_orders_request_id ON orders (request_id);
+CREATE INDEX IF NOT EXISTS idx_orders_ref_order_id ON orders (ref_order_id);
+CREATE INDEX IF NOT EXISTS idx_orders_wallet_create_at ON orders (wallet_id, create_at);
+
+CREATE TABLE IF NOT EXISTS api_request_ids (
+	id uuid PRIMARY KEY,
+	ip text NOT NULL DEFAULT '',
+	create_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_api_request_ids_create_at ON api_request_ids (create_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS api_request_ids;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS wallets;
+DROP TABLE IF EXISTS users;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "create holds",
+		Up: `
+CREATE TABLE IF NOT EXISTS holds (
+	id uuid PRIMARY KEY,
+	user_id bigint NOT NULL,
+	wallet_id bigint NOT NULL,
+	order_id uuid NOT NULL,
+	game_id bigint NOT NULL DEFAULT 0,
+	amount numeric(20,4) NOT NULL,
+	captured numeric(20,4) NOT NULL DEFAULT 0,
+	status text NOT NULL,
+	expire_at timestamptz NOT NULL,
+	create_at timestamptz NOT NULL DEFAULT now(),
+	update_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT fk_holds_wallet FOREIGN KEY (wallet_id) REFERENCES wallets (id),
+	CONSTRAINT fk_holds_order FOREIGN KEY (order_id) REFERENCES orders (id),
+	CONSTRAINT chk_holds_captured CHECK (captured >= 0 AND captured <= amount)
+);
+CREATE INDEX IF NOT EXISTS idx_holds_wallet_id ON holds (wallet_id);
+CREATE INDEX IF NOT EXISTS idx_holds_status_expire_at ON holds (status, expire_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS holds;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "create player_tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS player_tokens (
+	token text PRIMARY KEY,
+	agent text NOT NULL,
+	"user" text NOT NULL,
+	expire_at timestamptz NOT NULL,
+	revoked_at timestamptz,
+	create_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_player_tokens_expire_at ON player_tokens (expire_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS player_tokens;
+`,
+	},
+	{
+		Version: 4,
+		Name:    "create fx_rates, add order conversion",
+		Up: `
+CREATE TABLE IF NOT EXISTS fx_rates (
+	id bigserial PRIMARY KEY,
+	from_currency varchar(3) NOT NULL,
+	to_currency varchar(3) NOT NULL,
+	rate numeric(20,8) NOT NULL,
+	effective_at timestamptz NOT NULL,
+	create_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT chk_fx_rates_rate CHECK (rate > 0)
+);
+CREATE INDEX IF NOT EXISTS idx_fx_rates_pair ON fx_rates (from_currency, to_currency, effective_at);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS fx_currency varchar(3) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS fx_amount numeric(20,4) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS rate numeric(20,8);
+`,
+		Down: `
+ALTER TABLE orders DROP COLUMN IF EXISTS rate;
+ALTER TABLE orders DROP COLUMN IF EXISTS fx_amount;
+ALTER TABLE orders DROP COLUMN IF EXISTS fx_currency;
+
+DROP TABLE IF EXISTS fx_rates;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "create journal_entries journal_lines",
+		Up: `
+CREATE TABLE IF NOT EXISTS journal_entries (
+	id uuid PRIMARY KEY,
+	order_id uuid,
+	op_type text NOT NULL,
+	create_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_order_id ON journal_entries (order_id);
+
+CREATE TABLE IF NOT EXISTS journal_lines (
+	id bigserial PRIMARY KEY,
+	entry_id uuid NOT NULL,
+	account text NOT NULL,
+	currency varchar(3) NOT NULL,
+	amount numeric(20,4) NOT NULL,
+	create_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT fk_journal_lines_entry FOREIGN KEY (entry_id) REFERENCES journal_entries (id)
+);
+CREATE INDEX IF NOT EXISTS idx_journal_lines_entry_id ON journal_lines (entry_id);
+CREATE INDEX IF NOT EXISTS idx_journal_lines_account ON journal_lines (account);
+`,
+		Down: `
+DROP TABLE IF EXISTS journal_lines;
+DROP TABLE IF EXISTS journal_entries;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "create sync_checkpoints",
+		Up: `
+CREATE TABLE IF NOT EXISTS sync_checkpoints (
+	name text PRIMARY KEY,
+	last_id text NOT NULL DEFAULT '',
+	update_at timestamptz NOT NULL DEFAULT now()
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS sync_checkpoints;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "add api_request_ids outcome",
+		Up: `
+ALTER TABLE api_request_ids ADD COLUMN IF NOT EXISTS fingerprint text NOT NULL DEFAULT '';
+ALTER TABLE api_request_ids ADD COLUMN IF NOT EXISTS status integer NOT NULL DEFAULT 0;
+ALTER TABLE api_request_ids ADD COLUMN IF NOT EXISTS outcome text NOT NULL DEFAULT '';
+`,
+		Down: `
+ALTER TABLE api_request_ids DROP COLUMN IF EXISTS outcome;
+ALTER TABLE api_request_ids DROP COLUMN IF EXISTS status;
+ALTER TABLE api_request_ids DROP COLUMN IF EXISTS fingerprint;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "scope api_request_ids by agent, add expire_at",
+		Up: `
+ALTER TABLE api_request_ids ADD COLUMN IF NOT EXISTS agent text NOT NULL DEFAULT '';
+ALTER TABLE api_request_ids ADD COLUMN IF NOT EXISTS expire_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE api_request_ids DROP CONSTRAINT IF EXISTS api_request_ids_pkey;
+ALTER TABLE api_request_ids ADD CONSTRAINT api_request_ids_pkey PRIMARY KEY (agent, id);
+DROP INDEX IF EXISTS idx_api_request_ids_create_at;
+CREATE INDEX IF NOT EXISTS idx_api_request_ids_expire_at ON api_request_ids (expire_at);
+`,
+		Down: `
+DELETE FROM api_request_ids a USING api_request_ids b WHERE a.id = b.id AND a.agent > b.agent;
+DROP INDEX IF EXISTS idx_api_request_ids_expire_at;
+CREATE INDEX IF NOT EXISTS idx_api_request_ids_create_at ON api_request_ids (create_at);
+ALTER TABLE api_request_ids DROP CONSTRAINT IF EXISTS api_request_ids_pkey;
+ALTER TABLE api_request_ids ADD CONSTRAINT api_request_ids_pkey PRIMARY KEY (id);
+ALTER TABLE api_request_ids DROP COLUMN IF EXISTS expire_at;
+ALTER TABLE api_request_ids DROP COLUMN IF EXISTS agent;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "index orders for search",
+		Up: `
+CREATE INDEX IF NOT EXISTS idx_orders_user_create_at ON orders (user_id, create_at, id);
+CREATE INDEX IF NOT EXISTS idx_orders_create_at ON orders (create_at, id);
+`,
+		Down: `
+DROP INDEX IF EXISTS idx_orders_create_at;
+DROP INDEX IF EXISTS idx_orders_user_create_at;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "create agents, users reference agents",
+		Up: `
+CREATE TABLE IF NOT EXISTS agents (
+	id bigint PRIMARY KEY,
+	name text NOT NULL DEFAULT '',
+	status text NOT NULL DEFAULT 'active',
+	currencies text NOT NULL DEFAULT '',
+	api_key text NOT NULL DEFAULT '',
+	secret text NOT NULL DEFAULT '',
+	callback_url text NOT NULL DEFAULT '',
+	max_amount numeric(20,4) NOT NULL DEFAULT 0,
+	create_at timestamptz NOT NULL DEFAULT now(),
+	update_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT chk_agents_status CHECK (status IN ('active', 'disabled')),
+	CONSTRAINT chk_agents_max_amount CHECK (max_amount >= 0)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agents_api_key ON agents (api_key) WHERE api_key <> '';
+
+-- agent of existing users, so the key can be added
+INSERT INTO agents (id, name)
+SELECT DISTINCT agent_id, 'agent ' || agent_id FROM users
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE users ALTER COLUMN agent_id DROP DEFAULT;
+ALTER TABLE users ADD CONSTRAINT fk_users_agent FOREIGN KEY (agent_id) REFERENCES agents (id);
+`,
+		Down: `
+ALTER TABLE users DROP CONSTRAINT IF EXISTS fk_users_agent;
+ALTER TABLE users ALTER COLUMN agent_id SET DEFAULT 0;
+DROP TABLE IF EXISTS agents;
+`,
+	},
+	{
+		Version: 11,
+		Name:    "agent wallet user",
+		Up: `
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS wallet_user text NOT NULL DEFAULT '';
+`,
+		Down: `
+ALTER TABLE agents DROP COLUMN IF EXISTS wallet_user;
+`,
+	},
+	{
+		Version: 12,
+		Name:    "create sync_drifts",
+		Up: `
+CREATE TABLE IF NOT EXISTS sync_drifts (
+	id bigserial PRIMARY KEY,
+	order_id uuid NOT NULL,
+	wallet_id bigint NOT NULL,
+	stream_id text NOT NULL DEFAULT '',
+	redis_before numeric(20,4) NOT NULL,
+	postgres_before numeric(20,4) NOT NULL,
+	create_at timestamptz NOT NULL DEFAULT now(),
+	CONSTRAINT fk_sync_drifts_wallet FOREIGN KEY (wallet_id) REFERENCES wallets (id)
+);
+CREATE INDEX IF NOT EXISTS idx_sync_drifts_wallet_id ON sync_drifts (wallet_id);
+`,
+		Down: `
+DROP TABLE IF EXISTS sync_drifts;
+`,
+	},
+	{
+		Version: 13,
+		Name:    "create repair_fences",
+		Up: `
+CREATE TABLE IF NOT EXISTS repair_fences (
+	resource text PRIMARY KEY,
+	token bigint NOT NULL,
+	update_at timestamptz NOT NULL DEFAULT now()
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS repair_fences;
+`,
+	},
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	names := make(map[string]int)
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, versions must run 1, 2, 3 without gap", i, m.Version)
+		}
+		if m.Name == "" {
+			t.Errorf("migration %d has no name", m.Version)
+		}
+		if v, ok := names[m.Name]; ok {
+			t.Errorf("migration %d has same name as %d", m.Version, v)
+		}
+		names[m.Name] = m.Version
+
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %d need both up and down", m.Version)
+		}
+	}
+
+	if LatestVersion() != len(migrations) {
+		t.Errorf("latest version %d, want %d", LatestVersion(), len(migrations))
+	}
+}
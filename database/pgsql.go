@@ -5,11 +5,11 @@ import (
 	"database/sql"
 	"log"
 	"sync"
-	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
+	"github.com/kyos0109/test-wallet/config"
 	"github.com/kyos0109/test-wallet/modules"
 )
 
@@ -22,6 +22,7 @@ var (
 	ctx      context.Context
 	once     sync.Once
 	dbClient *DBConn
+	dbConfig = &config.Default().Database
 )
 
 // InitWithCtx ...
@@ -29,19 +30,22 @@ func InitWithCtx(pctx *context.Context) {
 	ctx = *pctx
 }
 
+// InitWithConfig ...
+func InitWithConfig(c *config.DatabaseConfig) {
+	dbConfig = c
+}
+
 // GetDBInstance ...
 func GetDBInstance() *DBConn {
 	once.Do(func() {
-		dsn := "user=happy password=Aa123456 dbname=wallte port=5432 sslmode=disable TimeZone=Asia/Taipei"
-
-		sqlDB, err := sql.Open("pgx", dsn)
+		sqlDB, err := sql.Open("pgx", dbConfig.DSN)
 		if err != nil {
 			log.Fatalln(err)
 		}
 
-		sqlDB.SetMaxIdleConns(50)
-		sqlDB.SetMaxOpenConns(2000)
-		sqlDB.SetConnMaxIdleTime(time.Hour)
+		sqlDB.SetMaxIdleConns(dbConfig.MaxIdleConns)
+		sqlDB.SetMaxOpenConns(dbConfig.MaxOpenConns)
+		sqlDB.SetConnMaxIdleTime(dbConfig.ConnMaxIdleTime)
 
 		gormDB := &gorm.DB{}
 		gormDB, err = gorm.Open(postgres.New(postgres.Config{
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// isolation level names of config
+const (
+	ReadCommitted  = "read committed"
+	RepeatableRead = "repeatable read"
+	Serializable   = "serializable"
+)
+
+// txRetryBackoff base wait before run failed transaction again, grow with
+// every retry
+const txRetryBackoff = 5 * time.Millisecond
+
+// postgres sqlstate worth retrying whole transaction
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// postgres sqlstate of broken constraint
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// Transaction run fn in one transaction of configured isolation level, fn
+// may run again on serialization failure or deadlock, up to TxRetries
+// times, so fn must not keep state out of tx between runs. fn error
+// rollback and return as is
+func (db *DBConn) Transaction(fn func(tx *gorm.DB) error) error {
+	opts := &sql.TxOptions{Isolation: isolationLevel(dbConfig.Isolation)}
+
+	for i := 0; ; i++ {
+		err := db.Conn().Transaction(fn, opts)
+		if err == nil || !Retryable(err) || i >= dbConfig.TxRetries {
+			return err
+		}
+
+		time.Sleep(txRetryBackoff*time.Duration(i+1) + time.Duration(rand.Int63n(int64(txRetryBackoff))))
+	}
+}
+
+// Retryable error of transaction lost to a concurrent one
+func Retryable(err error) bool {
+	s := sqlState(err)
+	return s == serializationFailure || s == deadlockDetected
+}
+
+// UniqueViolation error of write on unique key already taken
+func UniqueViolation(err error) bool {
+	return sqlState(err) == uniqueViolation
+}
+
+// ForeignKeyViolation error of write or delete breaking foreign key
+func ForeignKeyViolation(err error) bool {
+	return sqlState(err) == foreignKeyViolation
+}
+
+func sqlState(err error) string {
+	var e interface{ SQLState() string }
+	if !errors.As(err, &e) {
+		return ""
+	}
+	return e.SQLState()
+}
+
+func isolationLevel(name string) sql.IsolationLevel {
+	switch name {
+	case RepeatableRead:
+		return sql.LevelRepeatableRead
+	case Serializable:
+		return sql.LevelSerializable
+	}
+	return sql.LevelReadCommitted
+}
@@ -0,0 +1,155 @@
+package errcode
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// Code stable number of api error, once given never renumbered or reused.
+// 1xxx bad request, 2xxx auth, 3xxx wallet state, 4xxx request id, 5xxx
+// server side
+type Code int
+
+// success
+const Success Code = 0
+
+// bad request
+const (
+	InvalidRequest     Code = 1000
+	InvalidAmount      Code = 1001
+	InvalidRequestID   Code = 1002
+	AmountPrecision    Code = 1003
+	CurrencyNotSupport Code = 1004
+	CurrencyMismatch   Code = 1005
+	NoRate             Code = 1006
+	RollbackRef        Code = 1007
+	RollbackAmount     Code = 1008
+	TransferSelf       Code = 1009
+	HoldTTL            Code = 1010
+	HoldAmount         Code = 1011
+	BalanceUsers       Code = 1012
+	OrderQuery         Code = 1013
+	InvalidCursor      Code = 1014
+	ExportFormat       Code = 1015
+	ExportRange        Code = 1016
+	UnknownAction      Code = 1017
+	InvalidRate        Code = 1018
+	CurrencyNotAllowed Code = 1019
+	OverAgentLimit     Code = 1020
+	InvalidAgent       Code = 1021
+	TransferTo         Code = 1022
+)
+
+// auth
+const (
+	MissingSignature Code = 2001
+	UnknownAPIKey    Code = 2002
+	BadTimestamp     Code = 2003
+	BadSignature     Code = 2004
+	SignatureReplay  Code = 2005
+	AgentMismatch    Code = 2006
+	AdminToken       Code = 2007
+	TokenInvalid     Code = 2008
+	TokenExpired     Code = 2009
+	UnknownAgent     Code = 2010
+	AgentDisabled    Code = 2011
+)
+
+// wallet state
+const (
+	UserNotFound      Code = 3001
+	InsufficientFunds Code = 3002
+	OrderNotFound     Code = 3003
+	AlreadyRollback   Code = 3004
+	NotRollbackable   Code = 3005
+	HoldNotFound      Code = 3006
+	HoldSettled       Code = 3007
+	HoldExpired       Code = 3008
+	AgentNotFound     Code = 3009
+	AgentExists       Code = 3010
+	AgentInUse        Code = 3011
+	AgentNoWallet     Code = 3012
+)
+
+// request id
+const (
+	DuplicateRequest  Code = 4001
+	RequestIDConflict Code = 4002
+	RequestPending    Code = 4003
+)
+
+// server side
+const (
+	Internal    Code = 5000
+	Busy        Code = 5001
+	LockTimeout Code = 5002
+	LockLost    Code = 5003
+	StaleToken  Code = 5004
+)
+
+// Error api error of catalogue, answered with its http status and code
+type Error struct {
+	Code    Code   `json:"code"`
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// catalogue first error made of every code
+var catalogue = make(map[Code]*Error)
+
+// catalogue entry of code without package error
+var (
+	_ = New(InvalidRequest, http.StatusBadRequest, "invalid request")
+	_ = New(Internal, http.StatusInternalServerError, "internal error")
+)
+
+// New catalogue error, make it once as package var and compare by value.
+// First error of code is its catalogue entry
+func New(code Code, status int, msg string) error {
+	e := &Error{Code: code, Status: status, Message: msg}
+	if _, ok := catalogue[code]; !ok {
+		catalogue[code] = e
+	}
+	return e
+}
+
+// Invalid bad request of bind or validate error, keep its message
+func Invalid(err error) error {
+	return &Error{Code: InvalidRequest, Status: http.StatusBadRequest, Message: err.Error()}
+}
+
+// Of catalogue error of err, error not in catalogue is internal with its
+// own message
+func Of(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Code: Internal, Status: http.StatusInternalServerError, Message: err.Error()}
+}
+
+// StatusOf http status of err, ok for nil
+func StatusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return Of(err).Status
+}
+
+// Catalogue every error code by number
+func Catalogue() []*Error {
+	list := make([]*Error, 0, len(catalogue))
+	for _, e := range catalogue {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Code < list[j].Code
+	})
+	return list
+}
@@ -0,0 +1,21 @@
+package errcode
+
+// Response envelope of every api answer, http and websocket, all versions.
+// Code is Success with data, or error code with message
+type Response struct {
+	Succes  bool        `json:"succes"`
+	Code    Code        `json:"code"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// OK success answer of data
+func OK(data interface{}) *Response {
+	return &Response{Succes: true, Code: Success, Data: data}
+}
+
+// Fail error answer of err, send with StatusOf(err)
+func Fail(err error) *Response {
+	e := Of(err)
+	return &Response{Succes: false, Code: e.Code, Message: e.Message}
+}
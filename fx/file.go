@@ -0,0 +1,66 @@
+package fx
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+// fileSource yaml or json list of rates, update rewrite whole file
+type fileSource struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Load ...
+func (s *fileSource) Load() ([]modules.FXRate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read()
+}
+
+// Save ...
+func (s *fileSource) Save(r *modules.FXRate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, err := s.read()
+	if err != nil {
+		return err
+	}
+	list = append(list, *r)
+
+	b, err := yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	// write aside then rename, reader never see half file
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *fileSource) read() ([]modules.FXRate, error) {
+	list := []modules.FXRate{}
+
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(b, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
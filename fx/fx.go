@@ -0,0 +1,230 @@
+package fx
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// rate source name
+const (
+	PostgresSource = "postgres"
+	FileSource     = "file"
+	MemorySource   = "memory"
+)
+
+// fx error
+var (
+	ErrNoRate   = errcode.New(errcode.NoRate, http.StatusBadRequest, "no rate for currency pair")
+	ErrCurrency = errcode.New(errcode.CurrencyNotSupport, http.StatusBadRequest, "currency not support")
+)
+
+// Source rate storage
+type Source interface {
+	// Load every rate
+	Load() ([]modules.FXRate, error)
+	// Save add one rate
+	Save(r *modules.FXRate) error
+}
+
+type pair struct {
+	from, to string
+}
+
+var (
+	fxConfig = &config.Default().FX
+	source   = newSource(fxConfig)
+	mu       sync.RWMutex
+	// rates of pair sorted by effective time
+	rates = make(map[pair][]modules.FXRate)
+)
+
+// InitWithConfig ...
+//
+// source is built here and only read after, handlers use it without lock
+func InitWithConfig(c *config.FXConfig) {
+	fxConfig = c
+	source = newSource(c)
+}
+
+func newSource(c *config.FXConfig) Source {
+	switch c.Source {
+	case FileSource:
+		return &fileSource{path: c.File}
+	case MemorySource:
+		return &memorySource{}
+	default:
+		return &pgSource{}
+	}
+}
+
+func getSource() Source {
+	return source
+}
+
+// Reload replace rate table with source content
+func Reload() error {
+	list, err := getSource().Load()
+	if err != nil {
+		return err
+	}
+
+	t := make(map[pair][]modules.FXRate)
+	for _, r := range list {
+		p := pair{r.FromCurrency, r.ToCurrency}
+		t[p] = append(t[p], r)
+	}
+	for _, l := range t {
+		sortRates(l)
+	}
+
+	mu.Lock()
+	rates = t
+	mu.Unlock()
+
+	return nil
+}
+
+// ReloadWorker reload rate table every interval, so rate updated on other
+// instance take effect here
+func ReloadWorker(ctx context.Context) {
+	log.Print("start fx reload worker...")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Print("stop fx reload worker...")
+			return
+		case <-time.After(fxConfig.ReloadInterval):
+			if err := Reload(); err != nil {
+				log.Println("fx reload:", err)
+			}
+		}
+	}
+}
+
+// Update save new rate, effective now if effective time not set
+func Update(r *modules.FXRate) error {
+	if _, err := money.CurrencyDecimals(r.FromCurrency); err != nil {
+		return ErrCurrency
+	}
+	if _, err := money.CurrencyDecimals(r.ToCurrency); err != nil {
+		return ErrCurrency
+	}
+	if r.FromCurrency == r.ToCurrency || r.Rate <= 0 {
+		return money.ErrRate
+	}
+
+	r.CreateAt = time.Now()
+	if r.EffectiveAt.IsZero() {
+		r.EffectiveAt = r.CreateAt
+	}
+
+	if err := getSource().Save(r); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	p := pair{r.FromCurrency, r.ToCurrency}
+	rates[p] = append(rates[p], *r)
+	sortRates(rates[p])
+
+	return nil
+}
+
+// Rates every loaded rate, by pair then effective time
+func Rates() []modules.FXRate {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := []modules.FXRate{}
+	for _, l := range rates {
+		list = append(list, l...)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.FromCurrency != b.FromCurrency {
+			return a.FromCurrency < b.FromCurrency
+		}
+		if a.ToCurrency != b.ToCurrency {
+			return a.ToCurrency < b.ToCurrency
+		}
+		return a.EffectiveAt.Before(b.EffectiveAt)
+	})
+
+	return list
+}
+
+// Lookup rate from currency to currency at time, use direct rate, then
+// inverse of opposite rate, then cross through base currency
+func Lookup(from, to string, at time.Time) (money.Rate, error) {
+	if from == to {
+		return money.RateScale, nil
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if r, ok := pairRate(from, to, at); ok {
+		return r, nil
+	}
+
+	base := fxConfig.BaseCurrency
+	if from != base && to != base {
+		a, aok := pairRate(from, base, at)
+		b, bok := pairRate(base, to, at)
+		if aok && bok {
+			return a.Cross(b), nil
+		}
+	}
+
+	return 0, ErrNoRate
+}
+
+// Convert amount of from currency into to currency with rate at now,
+// return converted amount and applied rate
+func Convert(amount money.Amount, from, to string) (money.Amount, money.Rate, error) {
+	r, err := Lookup(from, to, time.Now())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	a, err := amount.Convert(r, to)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return a, r, nil
+}
+
+func pairRate(from, to string, at time.Time) (money.Rate, bool) {
+	if r, ok := effective(rates[pair{from, to}], at); ok {
+		return r, true
+	}
+	if r, ok := effective(rates[pair{to, from}], at); ok {
+		return r.Inverse(), true
+	}
+	return 0, false
+}
+
+// effective last rate not after at
+func effective(l []modules.FXRate, at time.Time) (money.Rate, bool) {
+	i := sort.Search(len(l), func(i int) bool { return l[i].EffectiveAt.After(at) })
+	if i == 0 {
+		return 0, false
+	}
+	return l[i-1].Rate, true
+}
+
+func sortRates(l []modules.FXRate) {
+	sort.SliceStable(l, func(i, j int) bool { return l[i].EffectiveAt.Before(l[j].EffectiveAt) })
+}
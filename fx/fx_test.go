@@ -0,0 +1,122 @@
+package fx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+func mustRate(t *testing.T, s string) money.Rate {
+	t.Helper()
+
+	r, err := money.ParseRate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// useRates memory source holding only list
+func useRates(t *testing.T, list ...*modules.FXRate) {
+	t.Helper()
+
+	InitWithConfig(&config.FXConfig{Source: MemorySource, BaseCurrency: "USD", ReloadInterval: time.Minute})
+	mu.Lock()
+	rates = make(map[pair][]modules.FXRate)
+	mu.Unlock()
+
+	for _, r := range list {
+		if err := Update(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	now := time.Now()
+	useRates(t,
+		&modules.FXRate{FromCurrency: "USD", ToCurrency: "TWD", Rate: mustRate(t, "30"), EffectiveAt: now.Add(-time.Hour)},
+		&modules.FXRate{FromCurrency: "USD", ToCurrency: "TWD", Rate: mustRate(t, "31.5"), EffectiveAt: now.Add(-time.Minute)},
+		&modules.FXRate{FromCurrency: "USD", ToCurrency: "TWD", Rate: mustRate(t, "40"), EffectiveAt: now.Add(time.Hour)},
+		&modules.FXRate{FromCurrency: "JPY", ToCurrency: "USD", Rate: mustRate(t, "0.0065"), EffectiveAt: now.Add(-time.Hour)},
+	)
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		at      time.Time
+		want    string
+		wantErr error
+	}{
+		{"same currency", "TWD", "TWD", now, "1", nil},
+		{"direct, newest effective", "USD", "TWD", now, "31.5", nil},
+		{"direct at older time", "USD", "TWD", now.Add(-30 * time.Minute), "30", nil},
+		{"before any rate", "USD", "TWD", now.Add(-2 * time.Hour), "", ErrNoRate},
+		{"inverse", "TWD", "USD", now, "0.03174603", nil},
+		{"cross through base", "JPY", "TWD", now, "0.20475", nil},
+		{"no rate", "EUR", "TWD", now, "", ErrNoRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lookup(tt.from, tt.to, tt.at)
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != mustRate(t, tt.want) {
+				t.Errorf("rate %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	useRates(t, &modules.FXRate{FromCurrency: "USD", ToCurrency: "TWD", Rate: mustRate(t, "31.5")})
+
+	got, rate, err := Convert(money.FromInt(2), "USD", "TWD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != money.FromInt(63) || rate != mustRate(t, "31.5") {
+		t.Errorf("converted %s at %s, want 63 at 31.5", got, rate)
+	}
+
+	if _, _, err := Convert(money.FromInt(2), "USD", "EUR"); err != ErrNoRate {
+		t.Errorf("err %v, want %v", err, ErrNoRate)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    *modules.FXRate
+		wantErr error
+	}{
+		{"ok", &modules.FXRate{FromCurrency: "USD", ToCurrency: "TWD", Rate: 1}, nil},
+		{"unknown from", &modules.FXRate{FromCurrency: "XXX", ToCurrency: "TWD", Rate: 1}, ErrCurrency},
+		{"unknown to", &modules.FXRate{FromCurrency: "USD", ToCurrency: "XXX", Rate: 1}, ErrCurrency},
+		{"same currency", &modules.FXRate{FromCurrency: "USD", ToCurrency: "USD", Rate: 1}, money.ErrRate},
+		{"zero rate", &modules.FXRate{FromCurrency: "USD", ToCurrency: "TWD"}, money.ErrRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRates(t)
+			if err := Update(tt.rate); err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+
+			want := 0
+			if tt.wantErr == nil {
+				want = 1
+			}
+			if n := len(Rates()); n != want {
+				t.Errorf("rates %d, want %d", n, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package fx
+
+import "github.com/kyos0109/test-wallet/modules"
+
+// memorySource keep nothing, the loaded table is the only copy
+type memorySource struct{}
+
+// Load ...
+func (s *memorySource) Load() ([]modules.FXRate, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := []modules.FXRate{}
+	for _, l := range rates {
+		list = append(list, l...)
+	}
+	return list, nil
+}
+
+// Save ...
+func (s *memorySource) Save(r *modules.FXRate) error {
+	return nil
+}
@@ -0,0 +1,20 @@
+package fx
+
+import (
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+type pgSource struct{}
+
+// Load ...
+func (s *pgSource) Load() ([]modules.FXRate, error) {
+	list := []modules.FXRate{}
+	r := database.GetDBInstance().Conn().Order("effective_at").Find(&list)
+	return list, r.Error
+}
+
+// Save ...
+func (s *pgSource) Save(r *modules.FXRate) error {
+	return database.GetDBInstance().Conn().Create(r).Error
+}
@@ -3,11 +3,13 @@ module github.com/kyos0109/test-wallet
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-playground/validator/v10 v10.3.0
 	github.com/go-redis/redis/v8 v8.0.0-beta.8
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/websocket v1.4.2
+	gopkg.in/yaml.v2 v2.2.8
 	gorm.io/driver/postgres v1.0.5
 	gorm.io/gorm v1.20.5
 )
@@ -0,0 +1,152 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+
+	guuid "github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// account kind, account name is kind and owner joined by ':'
+const (
+	WalletAccount = "wallet"
+	HeldAccount   = "held"
+	AgentAccount  = "agent"
+	FXAccount     = "fx"
+	EquityAccount = "equity"
+
+	delimiter = ":"
+)
+
+// ErrUnbalanced ...
+var ErrUnbalanced = errors.New("journal entry not balanced")
+
+// Wallet spendable balance of user wallet
+func Wallet(agent, user, currency string) string {
+	return WalletAccount + delimiter + agent + delimiter + user + delimiter + currency
+}
+
+// Held amount reserved by holds of user wallet
+func Held(agent, user, currency string) string {
+	return HeldAccount + delimiter + agent + delimiter + user + delimiter + currency
+}
+
+// Agent counterparty of store and deduct, one per agent and currency
+func Agent(agent, currency string) string {
+	return AgentAccount + delimiter + agent + delimiter + currency
+}
+
+// FX conversion account, take one currency and give another
+func FX(currency string) string {
+	return FXAccount + delimiter + currency
+}
+
+// Equity source of opening and adjusting balances
+func Equity(currency string) string {
+	return EquityAccount + delimiter + currency
+}
+
+// Line ...
+func Line(account, currency string, amount money.Amount) modules.JournalLine {
+	return modules.JournalLine{Account: account, Currency: currency, Amount: amount}
+}
+
+// Move amount out of from into to, two lines
+func Move(from, to, currency string, amount money.Amount) []modules.JournalLine {
+	return []modules.JournalLine{Line(from, currency, -amount), Line(to, currency, amount)}
+}
+
+// Opening lines bring account from zero to amount
+func Opening(account, currency string, amount money.Amount) []modules.JournalLine {
+	return Move(Equity(currency), account, currency, amount)
+}
+
+// Reverse lines of entry, for rollback
+func Reverse(lines []modules.JournalLine) []modules.JournalLine {
+	r := make([]modules.JournalLine, 0, len(lines))
+	for _, l := range lines {
+		r = append(r, Line(l.Account, l.Currency, -l.Amount))
+	}
+	return r
+}
+
+// Balanced lines of every currency sum to zero
+func Balanced(lines []modules.JournalLine) error {
+	sum := make(map[string]money.Amount)
+	for _, l := range lines {
+		sum[l.Currency] += l.Amount
+	}
+	for _, s := range sum {
+		if s != 0 {
+			return ErrUnbalanced
+		}
+	}
+	return nil
+}
+
+// Post write one entry with lines in tx, lines must be balanced, zero lines
+// are dropped
+func Post(tx *gorm.DB, orderID *guuid.UUID, opType modules.WalletOps, lines []modules.JournalLine) error {
+	if err := Balanced(lines); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	e := &modules.JournalEntry{ID: guuid.New(), OrderID: orderID, OpType: opType, CreateAt: now}
+
+	rows := make([]modules.JournalLine, 0, len(lines))
+	for _, l := range lines {
+		if l.Amount == 0 {
+			continue
+		}
+		l.EntryID = e.ID
+		l.CreateAt = now
+		rows = append(rows, l)
+	}
+
+	if err := tx.Create(e).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Create(&rows).Error
+}
+
+// EntryLines lines posted for order, empty if order has no entry
+func EntryLines(db *gorm.DB, orderID guuid.UUID) ([]modules.JournalLine, error) {
+	lines := []modules.JournalLine{}
+	r := db.Model(&modules.JournalLine{}).
+		Joins("join journal_entries on journal_entries.id = journal_lines.entry_id").
+		Where("journal_entries.order_id = ?", orderID).
+		Find(&lines)
+	return lines, r.Error
+}
+
+// Balance account balance derived from ledger
+func Balance(db *gorm.DB, account string) (money.Amount, error) {
+	var s *money.Amount
+	r := db.Model(&modules.JournalLine{}).
+		Select("sum(amount)").Where("account = ?", account).Row()
+	if err := r.Scan(&s); err != nil {
+		return 0, err
+	}
+	if s == nil {
+		return 0, nil
+	}
+	return *s, nil
+}
+
+// Unbalanced entries whose lines do not sum to zero in some currency,
+// empty when money is conserved
+func Unbalanced(db *gorm.DB) ([]guuid.UUID, error) {
+	ids := []guuid.UUID{}
+	r := db.Model(&modules.JournalLine{}).
+		Group("entry_id, currency").Having("sum(amount) <> 0").
+		Distinct().Pluck("entry_id", &ids)
+	return ids, r.Error
+}
@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+func TestAccount(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{Wallet("100", "1", "TWD"), "wallet:100:1:TWD"},
+		{Held("100", "1", "TWD"), "held:100:1:TWD"},
+		{Agent("100", "TWD"), "agent:100:TWD"},
+		{FX("USD"), "fx:USD"},
+		{Equity("USD"), "equity:USD"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("account %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestBalanced(t *testing.T) {
+	w := Wallet("100", "1", "TWD")
+	a := Agent("100", "TWD")
+	ten := money.FromInt(10)
+
+	tests := []struct {
+		name    string
+		lines   []modules.JournalLine
+		wantErr error
+	}{
+		{"empty", nil, nil},
+		{"move", Move(a, w, "TWD", ten), nil},
+		{"opening", Opening(w, "TWD", ten), nil},
+		{"reverse", Reverse(Move(a, w, "TWD", ten)), nil},
+		{"two currency each balanced", append(Move(FX("TWD"), w, "TWD", ten), Move(a, FX("USD"), "USD", 1)...), nil},
+		{"one side", []modules.JournalLine{Line(w, "TWD", ten)}, ErrUnbalanced},
+		{"balanced only across currency", []modules.JournalLine{Line(w, "TWD", ten), Line(a, "USD", -ten)}, ErrUnbalanced},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Balanced(tt.lines); err != tt.wantErr {
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMoveReverse(t *testing.T) {
+	w := Wallet("100", "1", "TWD")
+	a := Agent("100", "TWD")
+
+	lines := Move(a, w, "TWD", money.FromInt(10))
+	want := []modules.JournalLine{
+		{Account: a, Currency: "TWD", Amount: -money.FromInt(10)},
+		{Account: w, Currency: "TWD", Amount: money.FromInt(10)},
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("move line %d %+v, want %+v", i, lines[i], want[i])
+		}
+	}
+
+	r := Reverse(lines)
+	for i := range lines {
+		if r[i].Account != lines[i].Account || r[i].Amount != -lines[i].Amount {
+			t.Errorf("reverse line %d %+v of %+v", i, r[i], lines[i])
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -12,21 +16,129 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/gin-gonic/gin"
+	"github.com/kyos0109/test-wallet/agent"
+	"github.com/kyos0109/test-wallet/auth"
+	"github.com/kyos0109/test-wallet/config"
 	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/fx"
 	kredis "github.com/kyos0109/test-wallet/redis"
+	"github.com/kyos0109/test-wallet/session"
 	"github.com/kyos0109/test-wallet/wallet"
 )
 
-var ctx = context.Background()
+var (
+	ctx        = context.Background()
+	configPath = flag.String("config", os.Getenv("WALLET_CONFIG"), "yaml or json config file")
+	backend    = flag.String("backend", "", "run every wallet api on this backend, \"memory\" for no redis/postgres")
+	reconcile  = flag.Bool("reconcile", false, "compare redis and postgres wallets, print drift report json and exit, exit 2 on drift left")
+	repairFrom = flag.String("repair-from", "", "with -reconcile, repair drift from \"redis\" or \"postgres\"")
+	migrate    = flag.String("migrate", "", "run schema migration \"up\", \"down\" or \"status\" and exit")
+	migrateTo  = flag.Int("migrate-version", -1, "with -migrate, target version, up default latest, down default one step")
+)
+
+// order export cli
+var (
+	export         = flag.String("export", "", "export orders as \"csv\" or \"ndjson\" to stdout and exit, need -export-agent, -export-from and -export-to")
+	exportAgent    = flag.String("export-agent", "", "with -export, agent of orders")
+	exportUser     = flag.String("export-user", "", "with -export, only this user")
+	exportCurrency = flag.String("export-currency", "", "with -export, only this currency")
+	exportFrom     = flag.String("export-from", "", "with -export, RFC3339 time, orders at or after")
+	exportTo       = flag.String("export-to", "", "with -export, RFC3339 time, orders before")
+	exportSource   = flag.String("export-source", wallet.PostgresBackend, "with -export, read orders from \"postgres\" or \"redis\"")
+)
 
 func init() {
 	database.InitWithCtx(&ctx)
 	kredis.InitWithCtx(&ctx)
-
-	go wallet.ExpiryWorker(ctx)
 }
 
 func main() {
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *backend != "" {
+		cfg.Backend = *backend
+	}
+
+	database.InitWithConfig(&cfg.Database)
+	kredis.InitWithConfig(&cfg.Redis)
+	wallet.InitWithConfig(&cfg.Wallet)
+	fx.InitWithConfig(&cfg.FX)
+
+	if *migrate != "" {
+		if err := runMigrate(*migrate, *migrateTo); err != nil {
+			log.Fatalln("migrate:", err)
+		}
+		return
+	}
+	if cfg.Database.Migrate && cfg.Backend == "" {
+		n, err := database.GetDBInstance().MigrateUp(0)
+		if err != nil {
+			log.Fatalln("migrate:", err)
+		}
+		log.Println("migrate up applied:", n)
+	}
+
+	if *reconcile {
+		r, err := wallet.Reconcile(*repairFrom)
+		if err != nil {
+			log.Fatalln("reconcile:", err)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+			log.Fatalln(err)
+		}
+		if r.Unresolved() > 0 {
+			os.Exit(2)
+		}
+		return
+	}
+
+	if *export != "" {
+		if err := runExport(*export); err != nil {
+			log.Fatalln("export:", err)
+		}
+		return
+	}
+
+	switch cfg.Backend {
+	case "":
+	case wallet.MemoryBackend:
+		wallet.UseMemory()
+		cfg.Session.Backend = session.MemoryBackend
+		cfg.Auth.ReplayBackend = "memory"
+		if cfg.FX.Source == fx.PostgresSource {
+			cfg.FX.Source = fx.MemorySource
+		}
+		if cfg.Agent.Source == agent.PostgresSource {
+			cfg.Agent.Source = agent.MemorySource
+		}
+		log.Println("use in memory wallet backend")
+	default:
+		log.Fatalln("unknown backend:", cfg.Backend)
+	}
+	session.InitWithConfig(&cfg.Session)
+	auth.InitWithConfig(&cfg.Auth)
+	agent.InitWithConfig(&cfg.Agent)
+
+	if err := fx.Reload(); err != nil {
+		log.Fatalln("fx load:", err)
+	}
+	if err := agent.Reload(); err != nil {
+		log.Fatalln("agent load:", err)
+	}
+	auth.SetCredentialStore(agent.Credentials(auth.Credentials()))
+
+	go wallet.ExpiryWorker(ctx)
+	go fx.ReloadWorker(ctx)
+	go agent.ReloadWorker(ctx)
+	go wallet.HoldExpiryWorker(ctx)
+	if cfg.Wallet.SyncEnabled && cfg.Backend == "" {
+		go wallet.SyncWorker(ctx)
+	}
+
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
@@ -34,7 +146,7 @@ func main() {
 	routerEntry(router)
 
 	srv := &http.Server{
-		Addr:    ":8080",
+		Addr:    cfg.HTTP.Addr,
 		Handler: router,
 	}
 
@@ -45,7 +157,7 @@ func main() {
 	}()
 
 	go func() {
-		http.ListenAndServe("0.0.0.0:6060", nil)
+		http.ListenAndServe(cfg.HTTP.PprofAddr, nil)
 	}()
 
 	quit := make(chan os.Signal, 1)
@@ -54,7 +166,7 @@ func main() {
 
 	log.Println("Shutdown Server ...")
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, cfg.HTTP.ShutdownTimeout)
 	defer cancel()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server Shutdown: ", err)
@@ -62,3 +174,61 @@ func main() {
 
 	log.Println("Server exiting")
 }
+
+// runExport order export cli, rows to stdout, count to log
+func runExport(format string) error {
+	q := &wallet.OrderQuery{
+		Agent:    *exportAgent,
+		User:     *exportUser,
+		Currency: *exportCurrency,
+	}
+
+	var err error
+	if q.From, err = time.Parse(time.RFC3339, *exportFrom); err != nil {
+		return errors.New("export-from: " + err.Error())
+	}
+	if q.To, err = time.Parse(time.RFC3339, *exportTo); err != nil {
+		return errors.New("export-to: " + err.Error())
+	}
+
+	s := wallet.GetStore(*exportSource)
+	if s == nil {
+		return errors.New("unknown export source " + *exportSource)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	n, err := wallet.Export(s, q, format, w)
+	log.Println("export rows:", n)
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// runMigrate migration cli, status print json
+func runMigrate(cmd string, version int) error {
+	db := database.GetDBInstance()
+
+	switch cmd {
+	case "up":
+		if version < 0 {
+			version = 0
+		}
+		n, err := db.MigrateUp(version)
+		log.Println("migrate up applied:", n)
+		return err
+	case "down":
+		n, err := db.MigrateDown(version)
+		log.Println("migrate down reverted:", n)
+		return err
+	case "status":
+		list, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(os.Stdout).Encode(list)
+	}
+
+	return errors.New("unknown command " + cmd)
+}
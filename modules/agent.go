@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// AgentStatus ...
+type AgentStatus string
+
+// agent status
+const (
+	AgentActive   AgentStatus = "active"
+	AgentDisabled AgentStatus = "disabled"
+)
+
+// Agent owner of users and api client of wallet. Empty Currencies allow
+// every currency, zero MaxAmount no limit on amount of one op. WalletUser
+// is user of agent whose wallets are the agent wallets, debit side of
+// agent top up, empty for none
+type Agent struct {
+	ID          int          `gorm:"primaryKey" json:"id"`
+	Name        string       `json:"name"`
+	Status      AgentStatus  `json:"status"`
+	Currencies  CurrencyList `gorm:"type:text" json:"currencies"`
+	APIKey      string       `json:"apikey"`
+	Secret      string       `json:"-"`
+	CallbackURL string       `json:"callbackurl"`
+	MaxAmount   money.Amount `gorm:"type:numeric(20,4)" json:"maxamount"`
+	WalletUser  string       `json:"walletuser"`
+	CreateAt    time.Time    `json:"createat"`
+	UpdateAt    time.Time    `json:"updateat"`
+}
+
+// Allow true if agent may use currency
+func (a *Agent) Allow(currency string) bool {
+	if len(a.Currencies) == 0 {
+		return true
+	}
+	for _, c := range a.Currencies {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrencyList currency codes, kept as comma joined text
+type CurrencyList []string
+
+// Value ...
+func (l CurrencyList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+// Scan ...
+func (l *CurrencyList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("scan %T into currency list", src)
+	}
+
+	*l = nil
+	if s != "" {
+		*l = strings.Split(s, ",")
+	}
+	return nil
+}
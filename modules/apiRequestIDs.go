@@ -7,8 +7,16 @@ import (
 )
 
 // APIRequestIDs ...
+//
+// request id is unique by agent, kept till ExpireAt. Status 0 while
+// request not finished, Outcome json of result to replay
 type APIRequestIDs struct {
-	ID       guuid.UUID `gorm:"primary_key;type:uuid"`
-	IP       string
-	CreateAt time.Time
+	Agent       string     `gorm:"primary_key"`
+	ID          guuid.UUID `gorm:"primary_key;type:uuid"`
+	IP          string
+	Fingerprint string
+	Status      int
+	Outcome     string
+	CreateAt    time.Time
+	ExpireAt    time.Time
 }
@@ -0,0 +1,18 @@
+package modules
+
+import (
+	"time"
+
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// FXRate exchange rate of currency pair, used from effective time until a
+// later rate of same pair take over
+type FXRate struct {
+	ID           int        `gorm:"primaryKey" json:"-" yaml:"-"`
+	FromCurrency string     `gorm:"size:3" json:"from" yaml:"from" binding:"required"`
+	ToCurrency   string     `gorm:"size:3" json:"to" yaml:"to" binding:"required"`
+	Rate         money.Rate `gorm:"type:numeric(20,8)" json:"rate" yaml:"rate" binding:"required"`
+	EffectiveAt  time.Time  `json:"effectiveat" yaml:"effectiveAt"`
+	CreateAt     time.Time  `json:"createat" yaml:"createAt"`
+}
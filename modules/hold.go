@@ -0,0 +1,34 @@
+package modules
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// HoldStatus ...
+type HoldStatus string
+
+const (
+	HoldHeld     HoldStatus = "held"
+	HoldCaptured HoldStatus = "captured"
+	HoldReleased HoldStatus = "released"
+	HoldExpired  HoldStatus = "expired"
+)
+
+// Hold reserved amount of wallet, wait for capture or release
+type Hold struct {
+	ID       guuid.UUID `gorm:"primary_key;type:uuid"`
+	UserID   int
+	WalletID int
+	OrderID  guuid.UUID `gorm:"type:uuid"`
+	GameID   int
+	Amount   money.Amount `gorm:"type:numeric(20,4)"`
+	Captured money.Amount `gorm:"type:numeric(20,4)"`
+	Status   HoldStatus
+	ExpireAt time.Time
+	CreateAt time.Time
+	UpdateAt time.Time
+}
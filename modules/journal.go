@@ -0,0 +1,28 @@
+package modules
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// JournalEntry one balanced posting, lines of every currency sum to zero
+type JournalEntry struct {
+	ID       guuid.UUID  `gorm:"primary_key;type:uuid"`
+	OrderID  *guuid.UUID `gorm:"type:uuid;index"`
+	OpType   WalletOps
+	CreateAt time.Time
+}
+
+// JournalLine change of one account, positive debit into account, negative
+// credit out of account, account balance is sum of its lines
+type JournalLine struct {
+	ID       int64        `gorm:"primaryKey"`
+	EntryID  guuid.UUID   `gorm:"type:uuid;index"`
+	Account  string       `gorm:"index"`
+	Currency string       `gorm:"size:3"`
+	Amount   money.Amount `gorm:"type:numeric(20,4)"`
+	CreateAt time.Time
+}
@@ -4,19 +4,26 @@ import (
 	"time"
 
 	guuid "github.com/google/uuid"
+
+	"github.com/kyos0109/test-wallet/money"
 )
 
 // OrderStatus ...
 type OrderStatus string
 
 const (
-	OrderOk     OrderStatus = "ok"
-	OrderFailed OrderStatus = "failed"
-	OrderOther  OrderStatus = "other"
-	OrderCreate OrderStatus = "create"
+	OrderOk       OrderStatus = "ok"
+	OrderFailed   OrderStatus = "failed"
+	OrderOther    OrderStatus = "other"
+	OrderCreate   OrderStatus = "create"
+	OrderRollback OrderStatus = "rollback"
 )
 
 // Order ...
+//
+// FXCurrency, FXAmount and Rate set on converted order, FXAmount is the
+// other side of conversion, Rate convert from request currency to credit
+// currency
 type Order struct {
 	ID           guuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
 	UserID       int
@@ -24,8 +31,12 @@ type Order struct {
 	GameID       int
 	OpType       WalletOps
 	RequestID    string
-	BeforeAmount float64
-	AfterAmount  float64
+	RefOrderID   *guuid.UUID  `gorm:"type:uuid"`
+	BeforeAmount money.Amount `gorm:"type:numeric(20,4)"`
+	AfterAmount  money.Amount `gorm:"type:numeric(20,4)"`
+	FXCurrency   string       `gorm:"size:3"`
+	FXAmount     money.Amount `gorm:"type:numeric(20,4)"`
+	Rate         *money.Rate  `gorm:"type:numeric(20,8)"`
 	Status       OrderStatus
 	Comment      string
 	CreateAt     time.Time
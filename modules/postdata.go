@@ -1,23 +1,132 @@
 package modules
 
+import (
+	"time"
+
+	"github.com/kyos0109/test-wallet/money"
+)
+
 // PostDatav2 ...
 type PostDatav2 struct {
-	Agent     string `json:"agent" validate:"required" binding:"required"`
-	User      string `json:"user" validate:"required" binding:"required"`
-	RequestID string `json:"requestid" validate:"required" binding:"required"`
-	Amount    int    `json:"amount" validate:"required" binding:"required"`
-	Token     string `json:"token" validate:"required" binding:"required"`
+	Agent     string       `json:"agent" validate:"required" binding:"required"`
+	User      string       `json:"user" validate:"required" binding:"required"`
+	RequestID string       `json:"requestid" validate:"required" binding:"required"`
+	Amount    money.Amount `json:"amount" validate:"required" binding:"required"`
+	Currency  string       `json:"currency" validate:"required" binding:"required"`
+	Token     string       `json:"token" validate:"required" binding:"required"`
 	ClientIP  string
 	Detail    interface{} `json:"detail"`
 }
 
-// PostDeductv2 ...
+// PostDeductv2 wallet currency set for deduct amount of currency from
+// wallet of other currency
 type PostDeductv2 struct {
+	GameID         int    `json:"gameid" binding:"required"`
+	WalletCurrency string `json:"walletcurrency"`
+}
+
+// PostStorev2 wallet currency set for store amount of currency into
+// wallet of other currency
+type PostStorev2 struct {
+	WalletCurrency string `json:"walletcurrency"`
+}
+
+// PostRollbackv2 reverse one order, by origin request id or order id
+type PostRollbackv2 struct {
+	RefRequestID string `json:"refrequestid"`
+	RefOrderID   string `json:"reforderid"`
+}
+
+// PostTransferv2 move amount to other user of same agent, to currency set
+// for credit wallet of other currency
+type PostTransferv2 struct {
+	ToUser     string `json:"touser"`
+	ToCurrency string `json:"tocurrency"`
+	FromAgent  bool   `json:"fromagent"`
+}
+
+// PostHoldv2 reserve amount, ttl in second, 0 for default
+type PostHoldv2 struct {
 	GameID int `json:"gameid" binding:"required"`
+	TTL    int `json:"ttl"`
+}
+
+// PostCapturev2 settle hold with amount, rest of hold go back to wallet
+type PostCapturev2 struct {
+	HoldID string `json:"holdid" binding:"required"`
+}
+
+// PostReleasev2 give whole hold back to wallet
+type PostReleasev2 struct {
+	HoldID string `json:"holdid" binding:"required"`
+}
+
+// PostWsAction command of websocket message, rest of message is post data
+// of the command
+type PostWsAction struct {
+	Action string `json:"action" validate:"required"`
+}
+
+// PostBalance balance query of websocket, token of the user required
+type PostBalance struct {
+	Agent    string `json:"agent" validate:"required"`
+	User     string `json:"user" validate:"required"`
+	Currency string `json:"currency"`
+	Token    string `json:"token" validate:"required"`
+}
+
+// QueryOrders order search query string, time in RFC3339
+type QueryOrders struct {
+	Agent     string    `form:"agent" binding:"required"`
+	User      string    `form:"user"`
+	Currency  string    `form:"currency"`
+	From      time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	OpType    WalletOps `form:"optype"`
+	GameID    int       `form:"gameid"`
+	Status    string    `form:"status"`
+	RequestID string    `form:"requestid"`
+	Cursor    string    `form:"cursor"`
+	Limit     int       `form:"limit"`
+}
+
+// QueryExport order export query string, from and to in RFC3339, format
+// csv or ndjson
+type QueryExport struct {
+	Agent    string    `form:"agent" binding:"required"`
+	User     string    `form:"user"`
+	Currency string    `form:"currency"`
+	From     time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00" binding:"required"`
+	To       time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00" binding:"required"`
+	OpType   WalletOps `form:"optype"`
+	Status   string    `form:"status"`
+	Format   string    `form:"format"`
+}
+
+// PostAgent agent of admin create or update, empty secret on update keep
+// the old one
+type PostAgent struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name" binding:"required"`
+	Status      AgentStatus  `json:"status"`
+	Currencies  []string     `json:"currencies"`
+	APIKey      string       `json:"apikey"`
+	Secret      string       `json:"secret"`
+	CallbackURL string       `json:"callbackurl"`
+	MaxAmount   money.Amount `json:"maxamount"`
+	WalletUser  string       `json:"walletuser"`
+}
+
+// PostIssueToken ...
+type PostIssueToken struct {
+	Agent string `json:"agent" binding:"required"`
+	User  string `json:"user" binding:"required"`
 }
 
-// PostStorev2 ...
-type PostStorev2 struct{}
+// PostRevokeToken ...
+type PostRevokeToken struct {
+	Token string `json:"token" binding:"required"`
+}
 
 // PostData from request json
 type PostData struct {
@@ -1,14 +1,20 @@
 package modules
 
-import "time"
+import (
+	"time"
 
-// Lock redis
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// Lock redis, RequestID name the holder. lease live TTL and renew every
+// RenewInterval till unlock
 type Lock struct {
 	Key           string
 	RequestID     string
 	TTL           time.Duration
 	Timeout       time.Duration
 	RetryInterval time.Duration
+	RenewInterval time.Duration
 }
 
 // RedisData write data
@@ -16,14 +22,25 @@ type RedisData struct {
 	UserKey      string
 	OrderKey     string
 	HashMap      map[string]interface{}
-	Amount       int
+	Amount       money.Amount
 	RequestID    string
+	RequestIDKey string `json:"-"`
+	// Claim json kept with script result under RequestIDKey, not in op log
+	Claim        string `json:"-"`
 	RequestIDTTL time.Duration
 	WallteOpKey  string
-	OpAmtBefor   int
-	OpAmtAfter   int
+	OpAmtBefor   money.Amount
+	OpAmtAfter   money.Amount
 	OpTimeSec    float64
 	OrderID      string
+	OrderIdxKey  string
+	OpType       WalletOps
+	RefOrderID   string       `json:",omitempty"`
+	HoldID       string       `json:",omitempty"`
+	ExpireAt     *time.Time   `json:",omitempty"`
+	FXCurrency   string       `json:",omitempty"`
+	FXAmount     money.Amount `json:",omitempty"`
+	Rate         money.Rate   `json:",omitempty"`
 	// PostData     interface{}
 	PostData *PostDatav2
 }
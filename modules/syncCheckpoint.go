@@ -0,0 +1,10 @@
+package modules
+
+import "time"
+
+// SyncCheckpoint last stream entry persisted by a sync worker
+type SyncCheckpoint struct {
+	Name     string `gorm:"primaryKey"`
+	LastID   string
+	UpdateAt time.Time
+}
@@ -0,0 +1,22 @@
+package modules
+
+import (
+	"time"
+
+	guuid "github.com/google/uuid"
+
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// SyncDrift postgres wallet not at redis before amount when sync apply a
+// redis op. op delta go on postgres balance as is, the gap kept here for
+// reconcile, never adjusted by sync
+type SyncDrift struct {
+	ID             int64      `gorm:"primaryKey"`
+	OrderID        guuid.UUID `gorm:"type:uuid"`
+	WalletID       int
+	StreamID       string
+	RedisBefore    money.Amount `gorm:"type:numeric(20,4)"`
+	PostgresBefore money.Amount `gorm:"type:numeric(20,4)"`
+	CreateAt       time.Time
+}
@@ -0,0 +1,13 @@
+package modules
+
+import "time"
+
+// PlayerToken session token of agent user
+type PlayerToken struct {
+	Token     string     `gorm:"primaryKey" json:"token"`
+	Agent     string     `json:"agent"`
+	User      string     `json:"user"`
+	ExpireAt  time.Time  `json:"expireat"`
+	RevokedAt *time.Time `json:"-"`
+	CreateAt  time.Time  `json:"createat"`
+}
@@ -1,22 +1,35 @@
 package modules
 
-import "time"
+import (
+	"time"
+
+	"github.com/kyos0109/test-wallet/money"
+)
 
 // WalletOps ...
 type WalletOps string
 
 const (
-	WalletStore  WalletOps = "store"
-	WalletDeduct WalletOps = "deduct"
-	WalletOther  WalletOps = "other"
-	WalletNone   WalletOps = "none"
+	WalletStore       WalletOps = "store"
+	WalletDeduct      WalletOps = "deduct"
+	WalletRollback    WalletOps = "rollback"
+	WalletTransferOut WalletOps = "transfer_out"
+	WalletTransferIn  WalletOps = "transfer_in"
+	WalletHold        WalletOps = "hold"
+	WalletCapture     WalletOps = "capture"
+	WalletRelease     WalletOps = "release"
+	WalletOpening     WalletOps = "opening"
+	WalletRepair      WalletOps = "repair"
+	WalletOther       WalletOps = "other"
+	WalletNone        WalletOps = "none"
 )
 
-// Wallet ...
+// Wallet one per user and currency
 type Wallet struct {
-	ID       int `gorm:"primaryKey"`
-	UserID   int
-	Amount   float64
-	Currency string
+	ID       int          `gorm:"primaryKey"`
+	UserID   int          `gorm:"uniqueIndex:idx_wallets_user_currency"`
+	Amount   money.Amount `gorm:"type:numeric(20,4)"`
+	Held     money.Amount `gorm:"type:numeric(20,4)"`
+	Currency string       `gorm:"size:3;uniqueIndex:idx_wallets_user_currency"`
 	UpdateAt time.Time
 }
@@ -0,0 +1,241 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Scale minor units kept per major unit, every amount is stored as integer
+// count of 1/Scale, enough for any currency below
+const (
+	Scale    = 10000
+	Decimals = 4
+)
+
+// currencyDecimals ISO 4217 minor unit of supported currency
+var currencyDecimals = map[string]int{
+	"TWD": 2,
+	"USD": 2,
+	"EUR": 2,
+	"CNY": 2,
+	"HKD": 2,
+	"THB": 2,
+	"MYR": 2,
+	"PHP": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"IDR": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// parse error
+var (
+	ErrFormat    = errors.New("amount format error")
+	ErrNegative  = errors.New("amount must not be negative")
+	ErrPrecision = errors.New("amount precision over currency")
+	ErrOverflow  = errors.New("amount overflow")
+	ErrCurrency  = errors.New("currency not support")
+)
+
+// Amount fixed point money, 1/Scale of major unit
+type Amount int64
+
+// FromInt whole major unit
+func FromInt(i int64) Amount {
+	return Amount(i * Scale)
+}
+
+// Currencies supported currency codes, sorted
+func Currencies() []string {
+	c := make([]string, 0, len(currencyDecimals))
+	for k := range currencyDecimals {
+		c = append(c, k)
+	}
+	sort.Strings(c)
+	return c
+}
+
+// CurrencyDecimals minor unit digits of currency
+func CurrencyDecimals(currency string) (int, error) {
+	d, ok := currencyDecimals[currency]
+	if !ok {
+		return 0, ErrCurrency
+	}
+	return d, nil
+}
+
+// Parse strict decimal string, no sign, no exponent, not more digits after
+// point than Decimals
+func Parse(s string) (Amount, error) {
+	v, err := parseFixed(s, Decimals)
+	return Amount(v), err
+}
+
+// parseFixed strict decimal string to integer count of 1/10^decimals
+func parseFixed(s string, decimals int) (int64, error) {
+	if s == "" {
+		return 0, ErrFormat
+	}
+	if s[0] == '-' {
+		return 0, ErrNegative
+	}
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+		if fracPart == "" {
+			return 0, ErrFormat
+		}
+	}
+	if intPart == "" || !digits(intPart) || !digits(fracPart) {
+		return 0, ErrFormat
+	}
+
+	// trailing zero do not add precision
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > decimals {
+		return 0, ErrPrecision
+	}
+
+	scale := pow10(decimals)
+	i, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || i > (1<<63-1)/scale-1 {
+		return 0, ErrOverflow
+	}
+
+	f := int64(0)
+	if fracPart != "" {
+		f, _ = strconv.ParseInt(fracPart+strings.Repeat("0", decimals-len(fracPart)), 10, 64)
+	}
+
+	return i*scale + f, nil
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// ParseCurrency Parse and check precision of currency
+func ParseCurrency(s, currency string) (Amount, error) {
+	a, err := Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	return a, a.CheckCurrency(currency)
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckCurrency amount must not carry more digits than currency minor unit
+func (a Amount) CheckCurrency(currency string) error {
+	d, err := CurrencyDecimals(currency)
+	if err != nil {
+		return err
+	}
+
+	if int64(a)%pow10(Decimals-d) != 0 {
+		return ErrPrecision
+	}
+
+	return nil
+}
+
+// Abs ...
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// String decimal without trailing zero, 12.5, 100
+func (a Amount) String() string {
+	return formatFixed(int64(a), Decimals)
+}
+
+func formatFixed(v int64, decimals int) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+
+	scale := pow10(decimals)
+	s := fmt.Sprintf("%s%d", sign, v/scale)
+	if f := v % scale; f != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%0*d", decimals, f), "0")
+	}
+	return s
+}
+
+// MarshalJSON as json number, exact decimal text
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accept json number or string, both strict parse
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value gorm write as numeric text
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan gorm read numeric column
+func (a *Amount) Scan(src interface{}) error {
+	var s string
+
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		*a = FromInt(v)
+		return nil
+	default:
+		return fmt.Errorf("money: can not scan %T", src)
+	}
+
+	neg := strings.HasPrefix(s, "-")
+	v, err := Parse(strings.TrimPrefix(s, "-"))
+	if err != nil {
+		return err
+	}
+	if neg {
+		v = -v
+	}
+	*a = v
+	return nil
+}
@@ -0,0 +1,140 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr error
+	}{
+		{"100", FromInt(100), nil},
+		{"0", 0, nil},
+		{"12.5", 125000, nil},
+		{"0.0001", 1, nil},
+		{"1.23450000", 12345, nil},
+		{"007", FromInt(7), nil},
+		{"0.00001", 0, ErrPrecision},
+		{"-1", 0, ErrNegative},
+		{"", 0, ErrFormat},
+		{"1.", 0, ErrFormat},
+		{".5", 0, ErrFormat},
+		{"1e3", 0, ErrFormat},
+		{"+1", 0, ErrFormat},
+		{"1,000", 0, ErrFormat},
+		{"922337203685477", 0, ErrOverflow},
+		{"922337203685476", FromInt(922337203685476), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		wantErr  error
+	}{
+		{"12.34", "TWD", nil},
+		{"12.345", "TWD", ErrPrecision},
+		{"100", "JPY", nil},
+		{"100.5", "JPY", ErrPrecision},
+		{"1.234", "KWD", nil},
+		{"1.2345", "KWD", ErrPrecision},
+		{"1", "XXX", ErrCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency+" "+tt.in, func(t *testing.T) {
+			if _, err := ParseCurrency(tt.in, tt.currency); err != tt.wantErr {
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{FromInt(100), "100"},
+		{125000, "12.5"},
+		{1, "0.0001"},
+		{-125000, "-12.5"},
+		{0, "0"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("%d string %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{`12.5`, 125000, false},
+		{`"12.5"`, 125000, false},
+		{`1e2`, 0, true},
+		{`"abc"`, 0, true},
+		{`-1`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var a Amount
+			err := json.Unmarshal([]byte(tt.in), &a)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want err %v", err, tt.wantErr)
+			}
+			if a != tt.want {
+				t.Errorf("got %s, want %s", a, tt.want)
+			}
+		})
+	}
+
+	b, err := json.Marshal(Amount(125000))
+	if err != nil || string(b) != "12.5" {
+		t.Errorf("marshal %s %v, want 12.5", b, err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want Amount
+	}{
+		{"12.5000", 125000},
+		{[]byte("-3.25"), -32500},
+		{int64(7), FromInt(7)},
+		{nil, 0},
+	}
+
+	for _, tt := range tests {
+		a := Amount(1)
+		if err := a.Scan(tt.in); err != nil {
+			t.Fatalf("scan %v: %v", tt.in, err)
+		}
+		if a != tt.want {
+			t.Errorf("scan %v got %s, want %s", tt.in, a, tt.want)
+		}
+	}
+}
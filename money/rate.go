@@ -0,0 +1,151 @@
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/kyos0109/test-wallet/errcode"
+)
+
+// RateScale exchange rate kept as integer count of 1/RateScale
+const (
+	RateScale    = 100000000
+	RateDecimals = 8
+)
+
+// ErrRate rate must be positive
+var ErrRate = errcode.New(errcode.InvalidRate, http.StatusBadRequest, "rate must be positive")
+
+// Rate fixed point exchange rate, units of quote currency per one unit of
+// base currency
+type Rate int64
+
+// ParseRate strict decimal string, not more digits after point than
+// RateDecimals, must be positive
+func ParseRate(s string) (Rate, error) {
+	v, err := parseFixed(s, RateDecimals)
+	if err != nil {
+		return 0, err
+	}
+	if v == 0 {
+		return 0, ErrRate
+	}
+	return Rate(v), nil
+}
+
+// Inverse rate of opposite direction, rounded half up
+func (r Rate) Inverse() Rate {
+	if r <= 0 {
+		return 0
+	}
+	return Rate(mulDiv(RateScale, RateScale, int64(r)))
+}
+
+// Cross chain rate r then o, rounded half up
+func (r Rate) Cross(o Rate) Rate {
+	return Rate(mulDiv(int64(r), int64(o), RateScale))
+}
+
+// Convert amount by rate, rounded half up to minor unit of currency
+func (a Amount) Convert(r Rate, currency string) (Amount, error) {
+	d, err := CurrencyDecimals(currency)
+	if err != nil {
+		return 0, err
+	}
+	if r <= 0 {
+		return 0, ErrRate
+	}
+
+	unit := pow10(Decimals - d)
+	v := mulDiv(int64(a), int64(r), RateScale*unit)
+	if v < 0 || v > (1<<63-1)/unit {
+		return 0, ErrOverflow
+	}
+
+	return Amount(v * unit), nil
+}
+
+// mulDiv x*y/z rounded half up, big int so x*y can not overflow, -1 if
+// result not fit int64
+func mulDiv(x, y, z int64) int64 {
+	n := new(big.Int).Mul(big.NewInt(x), big.NewInt(y))
+	n.Add(n, big.NewInt(z/2))
+	n.Quo(n, big.NewInt(z))
+	if !n.IsInt64() {
+		return -1
+	}
+	return n.Int64()
+}
+
+// String decimal without trailing zero
+func (r Rate) String() string {
+	return formatFixed(int64(r), RateDecimals)
+}
+
+// MarshalJSON as json number, exact decimal text
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accept json number or string, both strict parse
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+
+	v, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// UnmarshalYAML rate file, same strict parse as json
+func (r *Rate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	v, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
+
+// MarshalYAML rate file, decimal text
+func (r Rate) MarshalYAML() (interface{}, error) {
+	return r.String(), nil
+}
+
+// Value gorm write as numeric text
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+// Scan gorm read numeric column
+func (r *Rate) Scan(src interface{}) error {
+	var s string
+
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("money: can not scan rate %T", src)
+	}
+
+	v, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
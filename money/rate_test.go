@@ -0,0 +1,100 @@
+package money
+
+import "testing"
+
+func mustRate(t *testing.T, s string) Rate {
+	t.Helper()
+
+	r, err := ParseRate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rate
+		wantErr error
+	}{
+		{"31.5", 3150000000, nil},
+		{"0.00000001", 1, nil},
+		{"0.000000001", 0, ErrPrecision},
+		{"0", 0, ErrRate},
+		{"-1", 0, ErrNegative},
+		{"abc", 0, ErrFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRate(tt.in)
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateInverseCross(t *testing.T) {
+	if got := mustRate(t, "30").Inverse(); got != mustRate(t, "0.03333333") {
+		t.Errorf("inverse of 30 is %s", got)
+	}
+	// half up at last digit
+	if got := mustRate(t, "3").Inverse(); got != mustRate(t, "0.33333333") {
+		t.Errorf("inverse of 3 is %s", got)
+	}
+	if got := mustRate(t, "1.5").Inverse(); got != mustRate(t, "0.66666667") {
+		t.Errorf("inverse of 1.5 is %s", got)
+	}
+	if got := Rate(0).Inverse(); got != 0 {
+		t.Errorf("inverse of 0 is %s", got)
+	}
+
+	if got := mustRate(t, "0.0065").Cross(mustRate(t, "31.5")); got != mustRate(t, "0.20475") {
+		t.Errorf("cross is %s", got)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		rate     string
+		currency string
+		want     string
+		wantErr  error
+	}{
+		{"to cent", "10", "31.5", "TWD", "315", nil},
+		{"round half up", "0.01", "0.5", "USD", "0.01", nil},
+		{"round down", "0.01", "0.49", "USD", "0", nil},
+		{"to no minor unit", "1", "145.678", "JPY", "146", nil},
+		{"to three digit", "1", "0.30712345", "KWD", "0.307", nil},
+		{"unknown currency", "1", "1", "XXX", "", ErrCurrency},
+		{"overflow", "900000000000000", "100", "USD", "", ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Parse(tt.amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := a.Convert(mustRate(t, tt.rate), tt.currency)
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := FromInt(1).Convert(0, "USD"); err != ErrRate {
+		t.Errorf("zero rate err %v, want %v", err, ErrRate)
+	}
+}
@@ -0,0 +1,209 @@
+package kredis
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+// lock error
+var (
+	ErrLockTimeout = errcode.New(errcode.LockTimeout, http.StatusServiceUnavailable, "lock wait to timeout")
+	ErrLockLost    = errcode.New(errcode.LockLost, http.StatusConflict, "lock lost, taken by other or expired")
+	ErrStaleToken  = errcode.New(errcode.StaleToken, http.StatusConflict, "fencing token older than last write")
+)
+
+// key prefix of lock and its token counter, and of resource fence
+const (
+	RedisLockPerfix  = "Locks"
+	RedisFencePerfix = "Fences"
+)
+
+// lockScript take lock only when free, and give holder next fencing token.
+// value is holder and token, so unlock of one hold never free the next
+//
+// KEYS: lock, token counter
+// ARGV: holder, ttl(ms)
+var lockScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. ':' .. token, 'PX', ARGV[2])
+return token
+`)
+
+// renewScript extend lock still held by value
+//
+// KEYS: lock
+// ARGV: value, ttl(ms)
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+`)
+
+// unlockScript delete lock still held by value
+//
+// KEYS: lock
+// ARGV: value
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// luaFence check token of write against newest token written to resource,
+// fenced false for write of older holder, which must not write
+const luaFence = `
+local function fenced(key, token)
+	token = tonumber(token)
+	if token < tonumber(redis.call('GET', key) or '0') then
+		return false
+	end
+	redis.call('SET', key, token)
+	return true
+end
+`
+
+// Lease lock held, renewed in background till UnLock. Token grow with
+// every hold of key, pass it to fenced write
+type Lease struct {
+	Key   string
+	Token int64
+
+	value string
+	stop  chan struct{}
+	done  chan struct{}
+	lost  chan struct{}
+}
+
+func lockKey(key string) string {
+	return RedisLockPerfix + RedisDelimiter + key
+}
+
+func lockTokenKey(key string) string {
+	return lockKey(key) + RedisDelimiter + "token"
+}
+
+// FenceKey fence of resource key, hold token of last fenced write
+func FenceKey(key string) string {
+	return RedisFencePerfix + RedisDelimiter + key
+}
+
+// NewLock lock on key with config ttl and timeout
+func NewLock(key, requestID string) *modules.Lock {
+	return &modules.Lock{
+		Key:           key,
+		RequestID:     requestID,
+		TTL:           redisConfig.LockTTL,
+		Timeout:       redisConfig.LockTimeout,
+		RetryInterval: redisConfig.LockRetryInterval,
+		RenewInterval: redisConfig.LockRenewInterval,
+	}
+}
+
+// TryLock wait for lock till timeout, lease is renewed till UnLock.
+//
+// lock and fence guard work spanning many calls, reconcile repair only.
+// wallet writes of player op are out of scope, they take no lock and are
+// not fenced: each is one lua script or one postgres tx, atomic by itself,
+// and repair write only over the balance it read, so player op in between
+// fail the repair instead of being lost
+func (r *RedisClient) TryLock(l *modules.Lock) (*Lease, error) {
+	deadline := time.Now().Add(l.Timeout)
+	ttl := strconv.FormatInt(l.TTL.Milliseconds(), 10)
+
+	for {
+		token, err := lockScript.Run(ctx, r.pool, []string{lockKey(l.Key), lockTokenKey(l.Key)}, l.RequestID, ttl).Int64()
+		if err != nil {
+			return nil, err
+		}
+
+		if token > 0 {
+			ls := &Lease{
+				Key:   l.Key,
+				Token: token,
+				value: l.RequestID + ":" + strconv.FormatInt(token, 10),
+				stop:  make(chan struct{}),
+				done:  make(chan struct{}),
+				lost:  make(chan struct{}),
+			}
+			go r.renew(ls, l)
+			return ls, nil
+		}
+
+		if !time.Now().Add(l.RetryInterval).Before(deadline) {
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(l.RetryInterval)
+	}
+}
+
+// renew extend lease every renew interval, redis error is retried till ttl
+// from last renew pass, then lease is lost
+func (r *RedisClient) renew(ls *Lease, l *modules.Lock) {
+	defer close(ls.done)
+
+	ttl := strconv.FormatInt(l.TTL.Milliseconds(), 10)
+	expire := time.Now().Add(l.TTL)
+	tick := time.NewTicker(l.RenewInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ls.stop:
+			return
+		case <-tick.C:
+			n, err := renewScript.Run(ctx, r.pool, []string{lockKey(ls.Key)}, ls.value, ttl).Int()
+			switch {
+			case err == nil && n == 1:
+				expire = time.Now().Add(l.TTL)
+				continue
+			case err != nil && time.Now().Before(expire):
+				continue
+			}
+			close(ls.lost)
+			return
+		}
+	}
+}
+
+// Lost closed when lease can not be renewed, work under it should stop
+func (ls *Lease) Lost() <-chan struct{} {
+	return ls.lost
+}
+
+// Held false once lease lost
+func (ls *Lease) Held() bool {
+	select {
+	case <-ls.lost:
+		return false
+	default:
+		return true
+	}
+}
+
+// UnLock stop renew and free lock if still held by lease, ErrLockLost when
+// it was not
+func (r *RedisClient) UnLock(ls *Lease) error {
+	close(ls.stop)
+	<-ls.done
+
+	n, err := unlockScript.Run(ctx, r.pool, []string{lockKey(ls.Key)}, ls.value).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+
+	return nil
+}
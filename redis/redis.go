@@ -7,30 +7,227 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/kyos0109/test-wallet/config"
 	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
 )
 
 const (
 	RedisDelimiter         = ":"
 	RedisOrderPerfix       = "Orders"
 	RedisUserPerfix        = "Users"
-	RedisLockOpsPerfix     = "LockOps"
 	RediswallteOpsPerfix   = "walletOps"
+	RedisOrderIdxPerfix    = "walletOrders"
+	RedisHoldPerfix        = "Holds"
+	RedisHoldExpiryKey     = "HoldExpiry"
+	RedisHashHeldKey       = "held"
+	RedisTokenPerfix       = "Tokens"
+	RedisSignPerfix        = "Signs"
+	RedisOutcomePerfix     = "Outcomes"
+	RedisRequestIDPerfix   = "RequestIDs"
+	RedisOpStreamKey       = "walletStream"
+	RedisOpStreamDeadKey   = "walletStreamDead"
+	RedisOpStreamFailKey   = "walletStreamFails"
 	RedisHashWalletKey     = "wallet"
 	RedisHashlastChangeKey = "lastChange"
 	RedisHashlastGameKey   = "lastGameID"
-	RedisLockTTL           = 3600
-	RedisLockTimeout       = 30
-	RedisLockRetryInterval = 1
 
-	PostDeductCmd = "deduct"
-	PostStoreCmd  = "store"
+	PostDeductCmd   = "deduct"
+	PostStoreCmd    = "store"
+	PostRollbackCmd = "rollback"
+	PostTransferCmd = "transfer"
+	PostHoldCmd     = "hold"
+	PostCaptureCmd  = "capture"
+	PostReleaseCmd  = "release"
+	PostBalanceCmd  = "balance"
 )
 
+// OpStreamMaxLen approximate cap of op stream. sync worker drop entries it
+// persisted so stream stay far below it, cap only bound the stream while
+// sync is off or stuck. op trimmed before sync is left to reconcile
+const OpStreamMaxLen = 1000000
+
+// luaPrelude shared by wallet scripts, amount in script is integer count of
+// 1/money.Scale, op log keep decimal text same as money.Amount json and the
+// raw Delta for rollback. record write op log to user ops list, order key,
+// order index and the op stream drained into postgres. finish keep script
+// result with the claim under request id key, same step as wallet change, so
+// a retry can replay it even if caller die before it save the outcome
+var luaPrelude = `
+local function dec(x)
+	return string.format('%d.%04d', math.floor(x / 10000), x % 10000)
+end
+
+local function setAmount(op, before, after, lastChange)
+	op['Amount'] = dec(after)
+	op['OpAmtBefor'] = dec(before)
+	op['OpAmtAfter'] = dec(after)
+	op['Delta'] = after - before
+	op['HashMap'] = {wallet = dec(after), lastChange = lastChange}
+end
+
+local function record(op, ops, order, index)
+	local log = cjson.encode(op)
+	redis.call('LPUSH', ops, log)
+	redis.call('SET', order, log)
+	redis.call('HSET', index, op['RequestID'], op['OrderID'])
+	redis.call('XADD', '` + RedisOpStreamKey + `', 'MAXLEN', '~', ` + strconv.Itoa(OpStreamMaxLen) + `, '*', 'op', log)
+end
+
+local function finish(key, ttl, claim, res)
+	local kept = {}
+	for i, v in ipairs(res) do
+		if type(v) == 'number' then
+			v = string.format('%d', v)
+		end
+		kept[i] = v
+	end
+	redis.call('SET', key, '{"claim":' .. claim .. ',"result":' .. cjson.encode(kept) .. '}', 'EX', ttl)
+	return res
+end
+`
+
+// walletOp script result status
+const (
+	WalletOpOk            = 1
+	WalletOpRequestRepeat = 0
+	WalletOpNoWallet      = -1
+	WalletOpNotEnough     = -2
+	WalletOpNoOrder       = -3
+	WalletOpRolledBack    = -4
+	WalletOpNoRollback    = -5
+	WalletOpAmountError   = -6
+	WalletOpNoHold        = -7
+	WalletOpHoldSettled   = -8
+	WalletOpHoldExpired   = -9
+)
+
+// hold settle mode
+const (
+	HoldCapture = "capture"
+	HoldRelease = "release"
+	HoldExpire  = "expire"
+)
+
+// walletOpScript check request id, check balance, update user wallet hash and
+// push the op log in one step, so balance and history can not disagree.
+//
+// KEYS: agent request id, user hash, wallet ops list, order, user order index
+// ARGV: op log json, request id ttl(sec), delta, last change, last game id, claim json
+var walletOpScript = redis.NewScript(luaPrelude + `
+if redis.call('SET', KEYS[1], '{}', 'NX', 'EX', ARGV[2]) == false then
+	return {0, 0, 0}
+end
+
+local function done(res)
+	return finish(KEYS[1], ARGV[2], ARGV[6], res)
+end
+
+local before = redis.call('HGET', KEYS[2], 'wallet')
+if before == false then
+	return done({-1, 0, 0})
+end
+before = tonumber(before)
+
+local after = before + tonumber(ARGV[3])
+if after < 0 then
+	return done({-2, before, before})
+end
+
+redis.call('HMSET', KEYS[2], 'wallet', string.format('%d', after), 'lastChange', ARGV[4])
+if ARGV[5] ~= '' then
+	redis.call('HSET', KEYS[2], 'lastGameID', ARGV[5])
+end
+
+local op = cjson.decode(ARGV[1])
+setAmount(op, before, after, ARGV[4])
+if ARGV[5] ~= '' then
+	op['HashMap']['lastGameID'] = tonumber(ARGV[5])
+end
+
+record(op, KEYS[3], KEYS[4], KEYS[5])
+
+return done({1, before, after})
+`)
+
+// rollbackScript reverse one order of user, the origin order is marked with
+// RollbackOrderID so it can not be rollback twice. only store and deduct
+// order can be reversed, as wallet.Rollbackable.
+//
+// KEYS: agent request id, user hash, wallet ops list, order, user order index
+// ARGV: op log json, request id ttl(sec), last change, ref request id, ref order id, amount,
+// claim json
+var rollbackScript = redis.NewScript(luaPrelude + `
+if redis.call('SET', KEYS[1], '{}', 'NX', 'EX', ARGV[2]) == false then
+	return {0, 0, 0}
+end
+
+local function done(res)
+	return finish(KEYS[1], ARGV[2], ARGV[7], res)
+end
+
+local refID = ARGV[5]
+if refID == '' then
+	refID = redis.call('HGET', KEYS[5], ARGV[4])
+	if refID == false then
+		return done({-3, 0, 0})
+	end
+end
+
+local refKey = 'Orders:' .. refID
+local ref = redis.call('GET', refKey)
+if ref == false then
+	return done({-3, 0, 0})
+end
+ref = cjson.decode(ref)
+if ref['UserKey'] ~= KEYS[2] then
+	return done({-3, 0, 0})
+end
+if ref['RollbackOrderID'] ~= nil then
+	return done({-4, 0, 0})
+end
+if ref['OpType'] ~= '` + string(modules.WalletStore) + `' and ref['OpType'] ~= '` + string(modules.WalletDeduct) + `' then
+	return done({-5, 0, 0})
+end
+if (ref['RefOrderID'] ~= nil and ref['RefOrderID'] ~= '') or ref['Delta'] == nil then
+	return done({-5, 0, 0})
+end
+
+local delta = -tonumber(ref['Delta'])
+if math.abs(delta) ~= tonumber(ARGV[6]) then
+	return done({-6, 0, 0})
+end
+
+local before = redis.call('HGET', KEYS[2], 'wallet')
+if before == false then
+	return done({-1, 0, 0})
+end
+before = tonumber(before)
+
+local after = before + delta
+if after < 0 then
+	return done({-2, before, before})
+end
+
+redis.call('HMSET', KEYS[2], 'wallet', string.format('%d', after), 'lastChange', ARGV[3])
+
+local op = cjson.decode(ARGV[1])
+setAmount(op, before, after, ARGV[3])
+op['RefOrderID'] = refID
+
+record(op, KEYS[3], KEYS[4], KEYS[5])
+
+ref['RollbackOrderID'] = op['OrderID']
+redis.call('SET', refKey, cjson.encode(ref))
+
+return done({1, before, after, refID})
+`)
+
 // RedisClient connect poll
 type RedisClient struct {
 	pool *redis.Client
@@ -40,6 +237,7 @@ var (
 	once        sync.Once
 	redisClient *RedisClient
 	ctx         context.Context
+	redisConfig = &config.Default().Redis
 )
 
 // InitWithCtx ...
@@ -47,19 +245,24 @@ func InitWithCtx(pctx *context.Context) {
 	ctx = *pctx
 }
 
+// InitWithConfig ...
+func InitWithConfig(c *config.RedisConfig) {
+	redisConfig = c
+}
+
 // GetRedisClientInstance Singleton
 func GetRedisClientInstance() *RedisClient {
 	once.Do(func() {
 		client := redis.NewClient(&redis.Options{
-			Addr:         "127.0.0.1:6379",
-			Password:     "",
-			MaxRetries:   3,
-			DialTimeout:  5 * time.Second,
-			WriteTimeout: 3 * time.Second,
-			PoolSize:     200,
+			Addr:         redisConfig.Addr,
+			Password:     redisConfig.Password,
+			MaxRetries:   redisConfig.MaxRetries,
+			DialTimeout:  redisConfig.DialTimeout,
+			WriteTimeout: redisConfig.WriteTimeout,
+			PoolSize:     redisConfig.PoolSize,
 			PoolTimeout:  0,
 			IdleTimeout:  0,
-			DB:           0,
+			DB:           redisConfig.DB,
 		})
 
 		pong, err := client.Ping(ctx).Result()
@@ -73,13 +276,179 @@ func GetRedisClientInstance() *RedisClient {
 	return redisClient
 }
 
-//UserWalletHMSet ...
+// transferScript move amount between two user wallets, each side got its own
+// order and op log, the two orders refer to each other.
+//
+// KEYS: agent request id, from hash, to hash, from ops list, to ops list,
+// from order, to order, from order index, to order index
+// ARGV: from op log json, to op log json, request id ttl(sec), amount, last change,
+// to amount, differ from amount when converted, claim json
+var transferScript = redis.NewScript(luaPrelude + `
+if redis.call('SET', KEYS[1], '{}', 'NX', 'EX', ARGV[3]) == false then
+	return {0, 0, 0, 0, 0}
+end
+
+local function done(res)
+	return finish(KEYS[1], ARGV[3], ARGV[7], res)
+end
+
+local fromBefore = redis.call('HGET', KEYS[2], 'wallet')
+local toBefore = redis.call('HGET', KEYS[3], 'wallet')
+if fromBefore == false or toBefore == false then
+	return done({-1, 0, 0, 0, 0})
+end
+fromBefore = tonumber(fromBefore)
+toBefore = tonumber(toBefore)
+
+local fromAfter = fromBefore - tonumber(ARGV[4])
+local toAfter = toBefore + tonumber(ARGV[6])
+if fromAfter < 0 then
+	return done({-2, fromBefore, fromBefore, toBefore, toBefore})
+end
+
+redis.call('HMSET', KEYS[2], 'wallet', string.format('%d', fromAfter), 'lastChange', ARGV[5])
+redis.call('HMSET', KEYS[3], 'wallet', string.format('%d', toAfter), 'lastChange', ARGV[5])
+
+local sides = {
+	{ARGV[1], fromBefore, fromAfter, KEYS[4], KEYS[6], KEYS[8]},
+	{ARGV[2], toBefore, toAfter, KEYS[5], KEYS[7], KEYS[9]},
+}
+for _, s in ipairs(sides) do
+	local op = cjson.decode(s[1])
+	setAmount(op, s[2], s[3], ARGV[5])
+
+	record(op, s[4], s[5], s[6])
+end
+
+return done({1, fromBefore, fromAfter, toBefore, toAfter})
+`)
+
+// holdScript move amount from wallet to held and keep a hold record with
+// expire time, the hold id is the hold order id.
+//
+// KEYS: agent request id, user hash, wallet ops list, order, user order index,
+// hold, hold expiry zset
+// ARGV: op log json, request id ttl(sec), amount, last change, expire at(unix sec), claim json
+var holdScript = redis.NewScript(luaPrelude + `
+if redis.call('SET', KEYS[1], '{}', 'NX', 'EX', ARGV[2]) == false then
+	return {0, 0, 0}
+end
+
+local function done(res)
+	return finish(KEYS[1], ARGV[2], ARGV[6], res)
+end
+
+local before = redis.call('HGET', KEYS[2], 'wallet')
+if before == false then
+	return done({-1, 0, 0})
+end
+before = tonumber(before)
+
+local amount = tonumber(ARGV[3])
+local after = before - amount
+if after < 0 then
+	return done({-2, before, before})
+end
+
+redis.call('HMSET', KEYS[2], 'wallet', string.format('%d', after), 'lastChange', ARGV[4])
+redis.call('HINCRBY', KEYS[2], 'held', amount)
+
+local op = cjson.decode(ARGV[1])
+setAmount(op, before, after, ARGV[4])
+
+redis.call('HMSET', KEYS[6],
+	'user', KEYS[2], 'ops', KEYS[3], 'index', KEYS[5],
+	'amount', ARGV[3], 'captured', 0, 'status', 'held', 'expireAt', ARGV[5])
+redis.call('ZADD', KEYS[7], ARGV[5], op['HoldID'])
+
+record(op, KEYS[3], KEYS[4], KEYS[5])
+
+return done({1, before, after})
+`)
+
+// settleScript capture part of hold and give the rest back, or give whole
+// hold back on release and expire. expire skip request id and only touch
+// holds already over expire time.
+//
+// KEYS: agent request id, user hash, wallet ops list, order, user order index,
+// hold, hold expiry zset
+// ARGV: op log json, request id ttl(sec), amount, last change, mode, now(unix sec), hold id,
+// claim json
+var settleScript = redis.NewScript(luaPrelude + `
+local mode = ARGV[5]
+if mode ~= 'expire' then
+	if redis.call('SET', KEYS[1], '{}', 'NX', 'EX', ARGV[2]) == false then
+		return {0, 0, 0}
+	end
+end
+
+local function done(res)
+	if mode == 'expire' then
+		return res
+	end
+	return finish(KEYS[1], ARGV[2], ARGV[8], res)
+end
+
+local h = redis.call('HMGET', KEYS[6], 'user', 'amount', 'status', 'expireAt')
+if h[1] == false or h[1] ~= KEYS[2] then
+	if mode == 'expire' then
+		redis.call('ZREM', KEYS[7], ARGV[7])
+	end
+	return done({-7, 0, 0})
+end
+if h[3] ~= 'held' then
+	return done({-8, 0, 0})
+end
+
+local held = tonumber(h[2])
+local expired = tonumber(h[4]) <= tonumber(ARGV[6])
+if mode == 'expire' and not expired then
+	return done({-8, 0, 0})
+end
+if mode ~= 'expire' and expired then
+	return done({-9, 0, 0})
+end
+
+local captured = 0
+local status = 'expired'
+if mode == 'capture' then
+	captured = tonumber(ARGV[3])
+	status = 'captured'
+	if captured > held then
+		return done({-6, 0, 0})
+	end
+elseif mode == 'release' then
+	status = 'released'
+	if tonumber(ARGV[3]) ~= held then
+		return done({-6, 0, 0})
+	end
+end
+
+local before = tonumber(redis.call('HGET', KEYS[2], 'wallet'))
+local after = before + held - captured
+
+redis.call('HMSET', KEYS[2], 'wallet', string.format('%d', after), 'lastChange', ARGV[4])
+redis.call('HINCRBY', KEYS[2], 'held', -held)
+redis.call('HMSET', KEYS[6], 'status', status, 'captured', captured)
+redis.call('ZREM', KEYS[7], ARGV[7])
+
+local op = cjson.decode(ARGV[1])
+setAmount(op, before, after, ARGV[4])
+op['Held'] = dec(held)
+op['Captured'] = dec(captured)
+
+record(op, KEYS[3], KEYS[4], KEYS[5])
+
+return done({1, before, after})
+`)
+
+// UserWalletHMSet ...
 func (r *RedisClient) UserWalletHMSet(rd *modules.RedisData) error {
 	return r.pool.HMSet(ctx, rd.UserKey, rd.HashMap).Err()
 }
 
-//UserWalletHGet ...
-func (r *RedisClient) UserWalletHGet(rd *modules.RedisData) int {
+// UserWalletHGet ...
+func (r *RedisClient) UserWalletHGet(rd *modules.RedisData) money.Amount {
 	val, err := r.pool.HGet(ctx, rd.UserKey, RedisHashWalletKey).Result()
 	if err == redis.Nil {
 		return -1
@@ -88,17 +457,17 @@ func (r *RedisClient) UserWalletHGet(rd *modules.RedisData) int {
 		panic(err)
 	}
 
-	i, err := strconv.Atoi(val)
+	i, err := strconv.ParseInt(val, 10, 64)
 	if err != nil {
 		panic(err)
 	}
 
-	return i
+	return money.Amount(i)
 }
 
 // Set key and value
 func (r *RedisClient) Set(rd *modules.RedisData) error {
-	return r.pool.Set(ctx, rd.UserKey, rd.Amount, 0).Err()
+	return r.pool.Set(ctx, rd.UserKey, int64(rd.Amount), 0).Err()
 }
 
 // SetOrderlog write to log
@@ -115,7 +484,7 @@ func (r *RedisClient) SetOrderlog(rd *modules.RedisData) {
 	}
 }
 
-// SetRequestIDLog write to log, and check
+// SetRequestIDLog write to log under agent request id key, and check
 func (r *RedisClient) SetRequestIDLog(rd *modules.RedisData) (bool, error) {
 	j, err := json.Marshal(&rd)
 	if err != nil {
@@ -123,13 +492,215 @@ func (r *RedisClient) SetRequestIDLog(rd *modules.RedisData) (bool, error) {
 		return false, err
 	}
 
-	ok, err := r.pool.SetNX(ctx, rd.RequestID, j, rd.RequestIDTTL*time.Second).Result()
+	ok, err := r.pool.SetNX(ctx, rd.RequestIDKey, j, rd.RequestIDTTL).Result()
 	return ok, err
 
 }
 
-// Get value convert int
-func (r *RedisClient) Get(rd *modules.RedisData) int {
+// UserWalletOp apply delta to user wallet with request id check and op log,
+// return one of WalletOp status
+func (r *RedisClient) UserWalletOp(rd *modules.RedisData, delta money.Amount) (int, error) {
+	j, err := json.Marshal(&rd)
+	if err != nil {
+		return 0, err
+	}
+
+	lastChange := ""
+	if t, ok := rd.HashMap[RedisHashlastChangeKey].(time.Time); ok {
+		lastChange = t.Format(time.RFC3339Nano)
+	}
+
+	lastGame := ""
+	if g, ok := rd.HashMap[RedisHashlastGameKey]; ok {
+		lastGame = fmt.Sprint(g)
+	}
+
+	keys := []string{rd.RequestIDKey, rd.UserKey, rd.WallteOpKey, rd.OrderKey, rd.OrderIdxKey}
+	res, err := walletOpScript.Run(ctx, r.pool, keys, j, int64(rd.RequestIDTTL/time.Second), int64(delta), lastChange, lastGame, claimOf(rd)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return walletOpResult(rd, res)
+}
+
+// UserWalletRollback reverse order by ref request id or ref order id,
+// return one of WalletOp status
+func (r *RedisClient) UserWalletRollback(rd *modules.RedisData, refRequestID string, amount money.Amount) (int, error) {
+	j, err := json.Marshal(&rd)
+	if err != nil {
+		return 0, err
+	}
+
+	lastChange := ""
+	if t, ok := rd.HashMap[RedisHashlastChangeKey].(time.Time); ok {
+		lastChange = t.Format(time.RFC3339Nano)
+	}
+
+	keys := []string{rd.RequestIDKey, rd.UserKey, rd.WallteOpKey, rd.OrderKey, rd.OrderIdxKey}
+	res, err := rollbackScript.Run(ctx, r.pool, keys, j, int64(rd.RequestIDTTL/time.Second), lastChange, refRequestID, rd.RefOrderID, int64(amount), claimOf(rd)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return walletOpResult(rd, res)
+}
+
+// UserWalletTransfer debit amount from one user wallet and credit to amount
+// to another, return one of WalletOp status
+func (r *RedisClient) UserWalletTransfer(from, to *modules.RedisData, amount, toAmount money.Amount) (int, error) {
+	fj, err := json.Marshal(from)
+	if err != nil {
+		return 0, err
+	}
+	tj, err := json.Marshal(to)
+	if err != nil {
+		return 0, err
+	}
+
+	lastChange := ""
+	if t, ok := from.HashMap[RedisHashlastChangeKey].(time.Time); ok {
+		lastChange = t.Format(time.RFC3339Nano)
+	}
+
+	keys := []string{
+		from.RequestIDKey, from.UserKey, to.UserKey, from.WallteOpKey, to.WallteOpKey,
+		from.OrderKey, to.OrderKey, from.OrderIdxKey, to.OrderIdxKey,
+	}
+	res, err := transferScript.Run(ctx, r.pool, keys, fj, tj, int64(from.RequestIDTTL/time.Second), int64(amount), lastChange, int64(toAmount), claimOf(from)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return transferResult(from, to, res)
+}
+
+func transferResult(from, to *modules.RedisData, res interface{}) (int, error) {
+	v, ok := res.([]interface{})
+	if !ok || len(v) != 5 {
+		return 0, errors.New("transfer script unexpected result")
+	}
+
+	from.OpAmtBefor = money.Amount(v[1].(int64))
+	from.OpAmtAfter = money.Amount(v[2].(int64))
+	from.Amount = from.OpAmtAfter
+	to.OpAmtBefor = money.Amount(v[3].(int64))
+	to.OpAmtAfter = money.Amount(v[4].(int64))
+	to.Amount = to.OpAmtAfter
+
+	return int(v[0].(int64)), nil
+}
+
+// UserWalletHold reserve amount until expireAt, rd.HoldID is the hold id,
+// return one of WalletOp status
+func (r *RedisClient) UserWalletHold(rd *modules.RedisData, amount money.Amount, expireAt time.Time) (int, error) {
+	j, err := json.Marshal(&rd)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []string{rd.RequestIDKey, rd.UserKey, rd.WallteOpKey, rd.OrderKey, rd.OrderIdxKey, holdKey(rd.HoldID), RedisHoldExpiryKey}
+	res, err := holdScript.Run(ctx, r.pool, keys, j, int64(rd.RequestIDTTL/time.Second), int64(amount), lastChangeOf(rd), expireAt.Unix(), claimOf(rd)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return walletOpResult(rd, res)
+}
+
+// UserWalletSettle capture, release or expire hold rd.HoldID,
+// return one of WalletOp status
+func (r *RedisClient) UserWalletSettle(rd *modules.RedisData, mode string, amount money.Amount) (int, error) {
+	j, err := json.Marshal(&rd)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []string{rd.RequestIDKey, rd.UserKey, rd.WallteOpKey, rd.OrderKey, rd.OrderIdxKey, holdKey(rd.HoldID), RedisHoldExpiryKey}
+	res, err := settleScript.Run(ctx, r.pool, keys, j, int64(rd.RequestIDTTL/time.Second), int64(amount), lastChangeOf(rd), mode, time.Now().Unix(), rd.HoldID, claimOf(rd)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return walletOpResult(rd, res)
+}
+
+// ExpiredHolds hold ids over expire time
+func (r *RedisClient) ExpiredHolds(limit int64) ([]string, error) {
+	return r.pool.ZRangeByScore(ctx, RedisHoldExpiryKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().Unix(), 10),
+		Count: limit,
+	}).Result()
+}
+
+// HoldOwner user hash, ops list and order index key of hold, empty if not exist
+func (r *RedisClient) HoldOwner(holdID string) (user, ops, index string, err error) {
+	v, err := r.pool.HMGet(ctx, holdKey(holdID), "user", "ops", "index").Result()
+	if err != nil || v[0] == nil {
+		return "", "", "", err
+	}
+
+	return v[0].(string), v[1].(string), v[2].(string), nil
+}
+
+func holdKey(holdID string) string {
+	return RedisHoldPerfix + RedisDelimiter + holdID
+}
+
+// claimOf claim json kept by script under request id key, empty object when
+// caller give none
+func claimOf(rd *modules.RedisData) string {
+	if rd.Claim == "" {
+		return "{}"
+	}
+	return rd.Claim
+}
+
+// ClaimResult status of script result kept with request id claim, set on rd
+// same as a script run, from and to of transfer
+func ClaimResult(result []string, rds ...*modules.RedisData) (int, error) {
+	res := make([]interface{}, len(result))
+	for i, v := range result {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			res[i] = n
+		} else {
+			res[i] = v
+		}
+	}
+
+	if len(rds) == 2 {
+		return transferResult(rds[0], rds[1], res)
+	}
+	return walletOpResult(rds[0], res)
+}
+
+func lastChangeOf(rd *modules.RedisData) string {
+	if t, ok := rd.HashMap[RedisHashlastChangeKey].(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+func walletOpResult(rd *modules.RedisData, res interface{}) (int, error) {
+	v, ok := res.([]interface{})
+	if !ok || len(v) < 3 {
+		return 0, errors.New("wallet op script unexpected result")
+	}
+
+	status := int(v[0].(int64))
+	rd.OpAmtBefor = money.Amount(v[1].(int64))
+	rd.OpAmtAfter = money.Amount(v[2].(int64))
+	rd.Amount = rd.OpAmtAfter
+	if len(v) > 3 {
+		rd.RefOrderID, _ = v[3].(string)
+	}
+
+	return status, nil
+}
+
+// Get value convert amount
+func (r *RedisClient) Get(rd *modules.RedisData) money.Amount {
 	val, err := r.pool.Get(ctx, rd.UserKey).Result()
 	if err == redis.Nil {
 		return -1
@@ -138,56 +709,253 @@ func (r *RedisClient) Get(rd *modules.RedisData) int {
 		panic(err)
 	}
 
-	i, err := strconv.Atoi(val)
+	i, err := strconv.ParseInt(val, 10, 64)
 	if err != nil {
 		panic(err)
 	}
 
-	return i
+	return money.Amount(i)
 }
 
-// LPush write list
-func (r *RedisClient) LPush(rd *modules.RedisData) {
-	j, err := json.Marshal(&rd)
+// SetJSON write value as json with ttl, 0 for no expire
+func (r *RedisClient) SetJSON(key string, v interface{}, ttl time.Duration) error {
+	j, err := json.Marshal(v)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 
-	err = r.pool.LPush(ctx, rd.WallteOpKey, j).Err()
+	return r.pool.Set(ctx, key, j, ttl).Err()
+}
+
+// GetJSON read json value, false if key not exist
+func (r *RedisClient) GetJSON(key string, v interface{}) (bool, error) {
+	val, err := r.pool.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
 	if err != nil {
-		fmt.Println(err)
+		return false, err
 	}
+
+	return true, json.Unmarshal(val, v)
 }
 
-// TryLock redis
-func (r *RedisClient) TryLock(l *modules.Lock) error {
-	getLock := make(chan bool, 1)
-	timeout := time.After(l.Timeout * time.Second)
+// SetNX mark key with ttl, false if already exist
+func (r *RedisClient) SetNX(key string, ttl time.Duration) (bool, error) {
+	return r.pool.SetNX(ctx, key, 1, ttl).Result()
+}
 
-	for {
-		ok, err := r.pool.SetNX(ctx, l.Key, l.RequestID, l.TTL*time.Second).Result()
-		if err != nil {
-			return err
-		}
-		getLock <- ok
-
-		select {
-		case <-timeout:
-			return errors.New(fmt.Sprintln(l.Key, l.RequestID, "Lock wait to timeout."))
-		case v := <-getLock:
-			if !v {
-				time.Sleep(l.RetryInterval * time.Second)
-				break
-			}
-			return nil
+// OpStreamEntry one op log of op stream
+type OpStreamEntry struct {
+	ID  string
+	Log string
+}
+
+// OpStreamRange op logs after stream id, oldest first, empty id for start
+func (r *RedisClient) OpStreamRange(after string, count int64) ([]OpStreamEntry, error) {
+	start := "-"
+	if after != "" {
+		start = nextStreamID(after)
+	}
+
+	msgs, err := r.pool.XRangeN(ctx, RedisOpStreamKey, start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]OpStreamEntry, 0, len(msgs))
+	for _, m := range msgs {
+		s, _ := m.Values["op"].(string)
+		list = append(list, OpStreamEntry{ID: m.ID, Log: s})
+	}
+	return list, nil
+}
+
+// OpStreamDel drop op logs already persisted, with their failed count
+func (r *RedisClient) OpStreamDel(ids ...string) error {
+	if err := r.pool.XDel(ctx, RedisOpStreamKey, ids...).Err(); err != nil {
+		return err
+	}
+	return r.pool.HDel(ctx, RedisOpStreamFailKey, ids...).Err()
+}
+
+// OpStreamFail count one more failed sync of op log, return count so far
+func (r *RedisClient) OpStreamFail(id string) (int64, error) {
+	return r.pool.HIncrBy(ctx, RedisOpStreamFailKey, id, 1).Result()
+}
+
+// OpStreamDeadEntry op log sync gave up on, kept with last error
+type OpStreamDeadEntry struct {
+	ID    string    `json:"id"`
+	Log   string    `json:"op"`
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// OpStreamDead push op log to dead letter list, newest first
+func (r *RedisClient) OpStreamDead(e OpStreamEntry, reason string) error {
+	j, err := json.Marshal(&OpStreamDeadEntry{ID: e.ID, Log: e.Log, Error: reason, At: time.Now()})
+	if err != nil {
+		return err
+	}
+	return r.pool.LPush(ctx, RedisOpStreamDeadKey, j).Err()
+}
+
+// OpStreamDeadLen count of op logs in dead letter list
+func (r *RedisClient) OpStreamDeadLen() (int64, error) {
+	return r.pool.LLen(ctx, RedisOpStreamDeadKey).Result()
+}
+
+// nextStreamID smallest id after id, xrange start is inclusive
+func nextStreamID(id string) string {
+	ms, seq := id, "0"
+	if i := strings.IndexByte(id, '-'); i >= 0 {
+		ms, seq = id[:i], id[i+1:]
+	}
+	n, _ := strconv.ParseUint(seq, 10, 64)
+	return ms + "-" + strconv.FormatUint(n+1, 10)
+}
+
+// repairScript set user wallet hash only when it still hold the balance
+// reconcile saw, a missing hash is expected as empty wallet. write is
+// fenced, -1 when token older than last repair
+//
+// KEYS: user hash, fence of user hash
+// ARGV: expect wallet, empty for missing, expect held, wallet, held, fencing token
+var repairScript = redis.NewScript(luaFence + `
+local wallet = redis.call('HGET', KEYS[1], 'wallet')
+local held = tonumber(redis.call('HGET', KEYS[1], 'held') or '0')
+
+if wallet == false then
+	if ARGV[1] ~= '' then
+		return 0
+	end
+elseif ARGV[1] == '' or tonumber(wallet) ~= tonumber(ARGV[1]) then
+	return 0
+end
+if held ~= tonumber(ARGV[2]) then
+	return 0
+end
+if not fenced(KEYS[2], ARGV[5]) then
+	return -1
+end
+
+redis.call('HMSET', KEYS[1], 'wallet', ARGV[3], 'held', ARGV[4])
+return 1
+`)
+
+// UserKeys every user wallet hash key
+func (r *RedisClient) UserKeys() ([]string, error) {
+	return r.ScanKeys(RedisUserPerfix + RedisDelimiter + "*")
+}
+
+// ScanKeys every key match glob pattern
+func (r *RedisClient) ScanKeys(match string) ([]string, error) {
+	keys := []string{}
+	iter := r.pool.Scan(ctx, 0, match, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// OrderLogs order log of every order id, empty for missing order
+func (r *RedisClient) OrderLogs(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = RedisOrderPerfix + RedisDelimiter + id
+	}
+
+	v, err := r.pool.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]string, len(v))
+	for i, s := range v {
+		logs[i], _ = s.(string)
+	}
+	return logs, nil
+}
+
+// UserWalletHGetAll every field of user hash, empty if hash not exist
+func (r *RedisClient) UserWalletHGetAll(key string) (map[string]string, error) {
+	return r.pool.HGetAll(ctx, key).Result()
+}
+
+// UserWalletBalance wallet and held of user hash, false if hash not exist
+func (r *RedisClient) UserWalletBalance(key string) (money.Amount, money.Amount, bool, error) {
+	v, err := r.pool.HMGet(ctx, key, RedisHashWalletKey, RedisHashHeldKey).Result()
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	s, ok := v[0].(string)
+	if !ok {
+		return 0, 0, false, nil
+	}
+	wallet, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	held := int64(0)
+	if s, ok := v[1].(string); ok {
+		if held, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, 0, false, err
 		}
 	}
+
+	return money.Amount(wallet), money.Amount(held), true, nil
+}
+
+// UserWalletRepair overwrite wallet and held of user hash under lease token
+// of key, false when hash no longer hold expect balance. expect nil for
+// missing hash
+func (r *RedisClient) UserWalletRepair(key string, token int64, expect *money.Amount, expectHeld, amount, held money.Amount) (bool, error) {
+	e := ""
+	if expect != nil {
+		e = strconv.FormatInt(int64(*expect), 10)
+	}
+
+	n, err := repairScript.Run(ctx, r.pool, []string{key, FenceKey(key)}, e, int64(expectHeld), int64(amount), int64(held), token).Int()
+	if err == nil && n < 0 {
+		return false, ErrStaleToken
+	}
+	return n == 1, err
+}
+
+// UserOpLogs count op logs of user wallet ops list from start, newest first
+func (r *RedisClient) UserOpLogs(key string, start, count int64) ([]string, error) {
+	return r.pool.LRange(ctx, key, start, start+count-1).Result()
+}
+
+// Exists count of keys exist
+func (r *RedisClient) Exists(keys ...string) (int64, error) {
+	return r.pool.Exists(ctx, keys...).Result()
+}
+
+// Del remove keys
+func (r *RedisClient) Del(keys ...string) error {
+	return r.pool.Del(ctx, keys...).Err()
 }
 
-// UnLock redis lock
-func (r *RedisClient) UnLock(l *modules.Lock) error {
-	return r.pool.Del(ctx, l.Key).Err()
+// LPush write list
+func (r *RedisClient) LPush(rd *modules.RedisData) {
+	j, err := json.Marshal(&rd)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = r.pool.LPush(ctx, rd.WallteOpKey, j).Err()
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
 // Close redis close
@@ -0,0 +1,109 @@
+package kredis
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+var testRedis *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	testRedis = miniredis.NewMiniRedis()
+	if err := testRedis.Start(); err != nil {
+		panic(err)
+	}
+
+	c := context.Background()
+	InitWithCtx(&c)
+	InitWithConfig(&config.RedisConfig{Addr: testRedis.Addr()})
+
+	code := m.Run()
+	testRedis.Close()
+	os.Exit(code)
+}
+
+const testUserKey = RedisUserPerfix + RedisDelimiter + "100:1:TWD"
+
+func testData(id string, opType modules.WalletOps) *modules.RedisData {
+	return &modules.RedisData{
+		UserKey:      testUserKey,
+		OrderKey:     RedisOrderPerfix + RedisDelimiter + id,
+		HashMap:      map[string]interface{}{RedisHashlastChangeKey: time.Now()},
+		RequestID:    "req-" + id,
+		RequestIDKey: RedisRequestIDPerfix + RedisDelimiter + "100:req-" + id,
+		RequestIDTTL: time.Minute,
+		WallteOpKey:  RediswallteOpsPerfix + RedisDelimiter + "100:1:TWD",
+		OrderID:      id,
+		OrderIdxKey:  RedisOrderIdxPerfix + RedisDelimiter + "100:1:TWD",
+		OpType:       opType,
+	}
+}
+
+func TestUserWalletRollbackOpType(t *testing.T) {
+	r := GetRedisClientInstance()
+	amount := money.FromInt(100)
+
+	tests := []struct {
+		name   string
+		opType modules.WalletOps
+		want   int
+	}{
+		{"store", modules.WalletStore, WalletOpOk},
+		{"deduct", modules.WalletDeduct, WalletOpOk},
+		{"hold", modules.WalletHold, WalletOpNoRollback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRedis.FlushAll()
+			testRedis.HSet(testUserKey, RedisHashWalletKey, "10000000")
+
+			ref := testData("ref-"+tt.name, tt.opType)
+			var status int
+			var err error
+			if tt.opType == modules.WalletHold {
+				ref.HoldID = ref.OrderID
+				status, err = r.UserWalletHold(ref, amount, time.Now().Add(time.Minute))
+			} else {
+				delta := amount
+				if tt.opType == modules.WalletDeduct {
+					delta = -amount
+				}
+				status, err = r.UserWalletOp(ref, delta)
+			}
+			if err != nil || status != WalletOpOk {
+				t.Fatalf("ref op status %d err %v", status, err)
+			}
+
+			rb := testData("rb-"+tt.name, modules.WalletRollback)
+			rb.RefOrderID = ref.OrderID
+			status, err = r.UserWalletRollback(rb, "", amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.want {
+				t.Errorf("rollback status %d, want %d", status, tt.want)
+			}
+
+			wallet, _, _, err := r.UserWalletBalance(testUserKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := money.FromInt(1000)
+			if tt.opType == modules.WalletHold {
+				want -= amount
+			}
+			if wallet != want {
+				t.Errorf("wallet %s, want %s", wallet, want)
+			}
+		})
+	}
+}
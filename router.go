@@ -1,6 +1,12 @@
 package main
 
-import "github.com/gin-gonic/gin"
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kyos0109/test-wallet/auth"
+)
 
 func routerEntry(router *gin.Engine) {
 	v1 := router.Group("/v1")
@@ -13,19 +19,59 @@ func routerEntry(router *gin.Engine) {
 		v2.POST("/api", chinSelectFunc)
 	}
 
-	v3 := router.Group("/v3")
+	v3 := router.Group("/v3", auth.SignatureMiddleware())
 	{
 		v3.POST("/api/deduct", DeductWalletController)
 		v3.POST("/api/store", StoreWalletController)
+		v3.POST("/api/rollback", RollbackWalletController)
+		v3.POST("/api/transfer", TransferWalletController)
+		v3.POST("/api/hold", HoldWalletController)
+		v3.POST("/api/capture", CaptureWalletController)
+		v3.POST("/api/release", ReleaseWalletController)
+		v3.GET("/api/balance", BalanceController)
+		v3.GET("/api/orders", OrdersController)
+		v3.GET("/api/orders/export", ExportOrdersController)
 	}
 
-	v4 := router.Group("/v4")
+	v4 := router.Group("/v4", auth.SignatureMiddleware())
 	{
 		v4.POST("/api/deduct", DeductWalletControllerDB)
 		v4.POST("/api/store", StoreWalletControllerDB)
+		v4.POST("/api/rollback", RollbackWalletControllerDB)
+		v4.POST("/api/transfer", TransferWalletControllerDB)
+		v4.POST("/api/hold", HoldWalletControllerDB)
+		v4.POST("/api/capture", CaptureWalletControllerDB)
+		v4.POST("/api/release", ReleaseWalletControllerDB)
+		v4.GET("/api/balance", BalanceControllerDB)
+		v4.GET("/api/orders", OrdersControllerDB)
+		v4.GET("/api/orders/export", ExportOrdersControllerDB)
+	}
+
+	token := router.Group("/token", auth.SignatureMiddleware())
+	{
+		token.POST("/issue", IssueTokenController)
+		token.POST("/revoke", RevokeTokenController)
+	}
+
+	// admin api fail closed, not mounted without admin auth
+	if auth.AdminConfigured() {
+		admin := router.Group("/admin", auth.AdminMiddleware())
+		admin.GET("/fx/rates", FXRatesController)
+		admin.POST("/fx/rates", UpdateFXRateController)
+		admin.GET("/orders", AdminOrdersController)
+		admin.GET("/orders/export", AdminExportOrdersController)
+		admin.GET("/errors", ErrorCodesController)
+		admin.GET("/agents", AgentsController)
+		admin.GET("/agents/:id", AgentController)
+		admin.POST("/agents", CreateAgentController)
+		admin.PUT("/agents/:id", UpdateAgentController)
+		admin.DELETE("/agents/:id", DeleteAgentController)
+	} else {
+		log.Println("admin api not mounted, need auth enabled and admin token")
 	}
 
-	ws := router.Group("/ws")
+	// handshake signed by agent, every message then checked against it
+	ws := router.Group("/ws", auth.SignatureMiddleware())
 	{
 		ws.GET("", WsWallte)
 	}
@@ -0,0 +1,52 @@
+package session
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+type memoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]modules.PlayerToken
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{tokens: make(map[string]modules.PlayerToken)}
+}
+
+// Save ...
+func (s *memoryStore) Save(t *modules.PlayerToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[t.Token] = *t
+	return nil
+}
+
+// Get ...
+func (s *memoryStore) Get(token string) (*modules.PlayerToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[token]
+	if !ok {
+		return nil, nil
+	}
+
+	return &t, nil
+}
+
+// Revoke ...
+func (s *memoryStore) Revoke(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.tokens[token]; ok {
+		now := time.Now()
+		t.RevokedAt = &now
+		s.tokens[token] = t
+	}
+	return nil
+}
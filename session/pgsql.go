@@ -0,0 +1,38 @@
+package session
+
+import (
+	"time"
+
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+type pgStore struct{}
+
+// Save ...
+func (s *pgStore) Save(t *modules.PlayerToken) error {
+	return database.GetDBInstance().Conn().Create(t).Error
+}
+
+// Get ...
+func (s *pgStore) Get(token string) (*modules.PlayerToken, error) {
+	t := &modules.PlayerToken{}
+
+	r := database.GetDBInstance().Conn().Find(&t, &modules.PlayerToken{Token: token})
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	if r.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return t, nil
+}
+
+// Revoke ...
+func (s *pgStore) Revoke(token string) error {
+	return database.GetDBInstance().Conn().
+		Model(&modules.PlayerToken{}).
+		Where("token = ? AND revoked_at IS NULL", token).
+		Update("revoked_at", time.Now()).Error
+}
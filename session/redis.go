@@ -0,0 +1,40 @@
+package session
+
+import (
+	"time"
+
+	"github.com/kyos0109/test-wallet/modules"
+	kredis "github.com/kyos0109/test-wallet/redis"
+)
+
+// redisExpiredKeep keep expired token a while, so caller got ErrTokenExpired
+// not ErrTokenInvalid
+const redisExpiredKeep = 24 * time.Hour
+
+type redisStore struct{}
+
+func tokenKey(token string) string {
+	return kredis.RedisTokenPerfix + kredis.RedisDelimiter + token
+}
+
+// Save ...
+func (s *redisStore) Save(t *modules.PlayerToken) error {
+	return kredis.GetRedisClientInstance().SetJSON(tokenKey(t.Token), t, time.Until(t.ExpireAt)+redisExpiredKeep)
+}
+
+// Get ...
+func (s *redisStore) Get(token string) (*modules.PlayerToken, error) {
+	t := &modules.PlayerToken{}
+
+	ok, err := kredis.GetRedisClientInstance().GetJSON(tokenKey(token), t)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Revoke drop key, revoked token same as unknown
+func (s *redisStore) Revoke(token string) error {
+	return kredis.GetRedisClientInstance().Del(tokenKey(token))
+}
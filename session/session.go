@@ -0,0 +1,130 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+// session store backend name
+const (
+	RedisBackend    = "redis"
+	PostgresBackend = "postgres"
+	MemoryBackend   = "memory"
+)
+
+// token error
+var (
+	ErrTokenInvalid = errcode.New(errcode.TokenInvalid, http.StatusUnauthorized, "token invalid")
+	ErrTokenExpired = errcode.New(errcode.TokenExpired, http.StatusUnauthorized, "token expired")
+)
+
+// Store token storage
+type Store interface {
+	// Save write token, keep it at least until expire
+	Save(t *modules.PlayerToken) error
+	// Get token, nil if not exist
+	Get(token string) (*modules.PlayerToken, error)
+	// Revoke mark token unusable
+	Revoke(token string) error
+}
+
+var (
+	sessionConfig = &config.Default().Session
+	store         = newStore(sessionConfig)
+)
+
+// InitWithConfig ...
+//
+// store is built here and only read after, handlers use it without lock
+func InitWithConfig(c *config.SessionConfig) {
+	sessionConfig = c
+	store = newStore(c)
+}
+
+func newStore(c *config.SessionConfig) Store {
+	switch c.Backend {
+	case PostgresBackend:
+		return &pgStore{}
+	case MemoryBackend:
+		return newMemoryStore()
+	default:
+		return &redisStore{}
+	}
+}
+
+func getStore() Store {
+	return store
+}
+
+// Issue new token for agent user
+func Issue(agent, user string) (*modules.PlayerToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	t := &modules.PlayerToken{
+		Token:    hex.EncodeToString(b),
+		Agent:    agent,
+		User:     user,
+		ExpireAt: now.Add(sessionConfig.TTL),
+		CreateAt: now,
+	}
+
+	if err := getStore().Save(t); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Validate token belong to agent user and not expired or revoked
+func Validate(token, agent, user string) error {
+	if token == "" {
+		return ErrTokenInvalid
+	}
+
+	t, err := getStore().Get(token)
+	if err != nil {
+		return err
+	}
+
+	if t == nil || t.RevokedAt != nil || t.Agent != agent || t.User != user {
+		return ErrTokenInvalid
+	}
+
+	if time.Now().After(t.ExpireAt) {
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+// Get usable or expired token, unknown or revoked token is ErrTokenInvalid
+func Get(token string) (*modules.PlayerToken, error) {
+	t, err := getStore().Get(token)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || t.RevokedAt != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	return t, nil
+}
+
+// Revoke token, unknown token is ErrTokenInvalid
+func Revoke(token string) error {
+	if _, err := Get(token); err != nil {
+		return err
+	}
+
+	return getStore().Revoke(token)
+}
@@ -0,0 +1,73 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+func TestValidate(t *testing.T) {
+	InitWithConfig(&config.SessionConfig{Backend: MemoryBackend, TTL: time.Hour})
+
+	tok, err := Issue("100", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _ := Issue("100", "1")
+	if err := Revoke(revoked.Token); err != nil {
+		t.Fatal(err)
+	}
+	expired := &modules.PlayerToken{Token: "expired", Agent: "100", User: "1", ExpireAt: time.Now().Add(-time.Second)}
+	if err := getStore().Save(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		agent   string
+		user    string
+		wantErr error
+	}{
+		{"ok", tok.Token, "100", "1", nil},
+		{"other user", tok.Token, "100", "2", ErrTokenInvalid},
+		{"other agent", tok.Token, "101", "1", ErrTokenInvalid},
+		{"empty", "", "100", "1", ErrTokenInvalid},
+		{"unknown", "abc", "100", "1", ErrTokenInvalid},
+		{"revoked", revoked.Token, "100", "1", ErrTokenInvalid},
+		{"expired", expired.Token, "100", "1", ErrTokenExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.token, tt.agent, tt.user); err != tt.wantErr {
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestConcurrentUse handlers issue and validate at once, run with -race
+func TestConcurrentUse(t *testing.T) {
+	InitWithConfig(&config.SessionConfig{Backend: MemoryBackend, TTL: time.Hour})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tok, err := Issue("100", "1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := Validate(tok.Token, "100", "1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
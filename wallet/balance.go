@@ -0,0 +1,66 @@
+package wallet
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// maxBalanceBatch most users in one balance query
+const maxBalanceBatch = 100
+
+// ErrBalanceUsers batch of no user or over maxBalanceBatch
+var ErrBalanceUsers = errcode.New(errcode.BalanceUsers, http.StatusBadRequest, "balance query need 1 to 100 users")
+
+// Balance wallet state of agent user in currency, Code and Error set
+// instead in batch item of user can not be read
+type Balance struct {
+	Agent      string       `json:"agent"`
+	User       string       `json:"user"`
+	Currency   string       `json:"currency"`
+	Amount     money.Amount `json:"amount"`
+	Held       money.Amount `json:"held"`
+	LastChange time.Time    `json:"lastchange"`
+	LastGameID int          `json:"lastgameid,omitempty"`
+	Code       errcode.Code `json:"code,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// QueryBalance balance of one user on store, currency empty for default
+func QueryBalance(s Store, agent, user, currency string) (*Balance, error) {
+	if currency == "" {
+		currency = DefaultCurrency()
+	}
+	if _, err := money.CurrencyDecimals(currency); err != nil {
+		return nil, ErrCurrency
+	}
+
+	return s.Balance(agent, user, currency)
+}
+
+// QueryBalances balance of every user on store, business error of one user
+// is kept in its item and not fail the query
+func QueryBalances(s Store, agent, currency string, users []string) ([]*Balance, error) {
+	if len(users) == 0 || len(users) > maxBalanceBatch {
+		return nil, ErrBalanceUsers
+	}
+
+	list := []*Balance{}
+	for _, u := range users {
+		b, err := QueryBalance(s, agent, u, currency)
+		if err != nil {
+			if StatusOf(err) >= http.StatusInternalServerError || err == ErrCurrency {
+				return nil, err
+			}
+			b = &Balance{Agent: agent, User: u, Currency: currency, Code: errcode.Of(err).Code, Error: err.Error()}
+			if b.Currency == "" {
+				b.Currency = DefaultCurrency()
+			}
+		}
+		list = append(list, b)
+	}
+
+	return list, nil
+}
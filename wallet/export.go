@@ -0,0 +1,198 @@
+package wallet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// export format
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// exportFlush rows written between flush of writer
+const exportFlush = 500
+
+// export error
+var (
+	ErrExportFormat = errcode.New(errcode.ExportFormat, http.StatusBadRequest, "export format must be csv or ndjson")
+	ErrExportRange  = errcode.New(errcode.ExportRange, http.StatusBadRequest, "export need agent, from and to, from before to")
+)
+
+// ExportColumns csv header and ndjson keys of export, same order and same
+// on every backend. Empty value stay in the row, column never dropped
+var ExportColumns = []string{
+	"orderid", "createat", "agent", "user", "currency", "optype", "requestid", "gameid",
+	"reforderid", "status", "before", "after", "fxcurrency", "fxamount", "rate",
+}
+
+// exportLine one ndjson line, keys of ExportColumns
+type exportLine struct {
+	OrderID    string              `json:"orderid"`
+	CreateAt   string              `json:"createat"`
+	Agent      string              `json:"agent"`
+	User       string              `json:"user"`
+	Currency   string              `json:"currency"`
+	OpType     modules.WalletOps   `json:"optype"`
+	RequestID  string              `json:"requestid"`
+	GameID     int                 `json:"gameid"`
+	RefOrderID string              `json:"reforderid"`
+	Status     modules.OrderStatus `json:"status"`
+	Before     money.Amount        `json:"before"`
+	After      money.Amount        `json:"after"`
+	FXCurrency string              `json:"fxcurrency"`
+	FXAmount   money.Amount        `json:"fxamount"`
+	Rate       money.Rate          `json:"rate"`
+}
+
+// exportWriter write record in one format
+type exportWriter interface {
+	Write(o *OrderRecord) error
+	Flush() error
+}
+
+// ExportContentType http content type of format
+func ExportContentType(format string) string {
+	if format == ExportNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// CheckExport error of export before any row written, export need a
+// closed time range
+func CheckExport(q *OrderQuery, format string) error {
+	if format != ExportCSV && format != ExportNDJSON {
+		return ErrExportFormat
+	}
+	if q.Agent == "" || q.From.IsZero() || q.To.IsZero() || !q.From.Before(q.To) {
+		return ErrExportRange
+	}
+
+	return nil
+}
+
+// Export write every order of query on store to w, oldest first, redis
+// one wallet after other. Rows go out as store read them and w is flushed
+// every exportFlush rows if it can, nothing kept, so size of export not
+// bound by memory. Return count of rows written
+func Export(s Store, q *OrderQuery, format string, w io.Writer) (int, error) {
+	if err := CheckExport(q, format); err != nil {
+		return 0, err
+	}
+
+	var ew exportWriter
+	if format == ExportNDJSON {
+		ew = &ndjsonWriter{enc: json.NewEncoder(w)}
+	} else {
+		cw := &csvWriter{w: csv.NewWriter(w)}
+		if err := cw.w.Write(ExportColumns); err != nil {
+			return 0, err
+		}
+		ew = cw
+	}
+	flusher, _ := w.(interface{ Flush() })
+
+	n := 0
+	err := s.ExportOrders(q, func(o *OrderRecord) error {
+		if err := ew.Write(o); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlush == 0 {
+			if err := ew.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	if err := ew.Flush(); err != nil {
+		return n, err
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	return n, nil
+}
+
+func exportTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// Write ...
+func (c *csvWriter) Write(o *OrderRecord) error {
+	return c.w.Write([]string{
+		o.OrderID,
+		exportTime(o.CreateAt),
+		o.Agent,
+		o.User,
+		o.Currency,
+		string(o.OpType),
+		o.RequestID,
+		strconv.Itoa(o.GameID),
+		o.RefOrderID,
+		string(o.Status),
+		o.BeforeAmount.String(),
+		o.AfterAmount.String(),
+		o.FXCurrency,
+		o.FXAmount.String(),
+		o.Rate.String(),
+	})
+}
+
+// Flush ...
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+// Write ...
+func (j *ndjsonWriter) Write(o *OrderRecord) error {
+	return j.enc.Encode(&exportLine{
+		OrderID:    o.OrderID,
+		CreateAt:   exportTime(o.CreateAt),
+		Agent:      o.Agent,
+		User:       o.User,
+		Currency:   o.Currency,
+		OpType:     o.OpType,
+		RequestID:  o.RequestID,
+		GameID:     o.GameID,
+		RefOrderID: o.RefOrderID,
+		Status:     o.Status,
+		Before:     o.BeforeAmount,
+		After:      o.AfterAmount,
+		FXCurrency: o.FXCurrency,
+		FXAmount:   o.FXAmount,
+		Rate:       o.Rate,
+	})
+}
+
+// Flush encoder write each line through
+func (j *ndjsonWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package wallet
+
+import (
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+)
+
+// Lines balanced ledger lines of op result. transfer out cover both side so
+// transfer in has none, rollback post the reverse of origin entry instead
+func (op *Operation) Lines() []modules.JournalLine {
+	c, a := op.Currency, op.Amount
+	w := ledger.Wallet(op.Agent, op.User, c)
+
+	switch op.OpType {
+	case modules.WalletStore:
+		if op.Rate != 0 {
+			return append(
+				ledger.Move(ledger.Agent(op.Agent, op.FXCurrency), ledger.FX(op.FXCurrency), op.FXCurrency, op.FXAmount),
+				ledger.Move(ledger.FX(c), w, c, a)...)
+		}
+		return ledger.Move(ledger.Agent(op.Agent, c), w, c, a)
+	case modules.WalletDeduct:
+		if op.Rate != 0 {
+			return append(
+				ledger.Move(w, ledger.FX(c), c, a),
+				ledger.Move(ledger.FX(op.FXCurrency), ledger.Agent(op.Agent, op.FXCurrency), op.FXCurrency, op.FXAmount)...)
+		}
+		return ledger.Move(w, ledger.Agent(op.Agent, c), c, a)
+	case modules.WalletTransferOut:
+		tc, ta := op.Credit()
+		to := ledger.Wallet(op.Agent, op.ToUser, tc)
+		if op.Rate != 0 {
+			return append(ledger.Move(w, ledger.FX(c), c, a), ledger.Move(ledger.FX(tc), to, tc, ta)...)
+		}
+		return ledger.Move(w, to, c, a)
+	case modules.WalletHold:
+		return ledger.Move(w, ledger.Held(op.Agent, op.User, c), c, a)
+	case modules.WalletCapture:
+		// wallet get back hold amount not captured
+		back := op.AfterAmount - op.BeforeAmount
+		held := ledger.Held(op.Agent, op.User, c)
+		return append(ledger.Move(held, ledger.Agent(op.Agent, c), c, a), ledger.Move(held, w, c, back)...)
+	case modules.WalletRelease:
+		return ledger.Move(ledger.Held(op.Agent, op.User, c), w, c, op.AfterAmount-op.BeforeAmount)
+	}
+
+	return nil
+}
@@ -0,0 +1,549 @@
+package wallet
+
+import (
+	"sync"
+	"time"
+
+	guuid "github.com/google/uuid"
+
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// MemoryBackend name of in process backend
+const MemoryBackend = "memory"
+
+// MemoryStore in process backend, for local run and test
+type MemoryStore struct {
+	mu         sync.Mutex
+	balances   map[string]money.Amount
+	requestIDs map[string]time.Time
+	outcomes   map[string]*Outcome
+	orders     []Operation
+	rollbacks  map[string]string
+	held       map[string]money.Amount
+	holds      map[string]*memHold
+	journal    []modules.JournalLine
+	entries    map[string][]modules.JournalLine
+}
+
+type memHold struct {
+	agent    string
+	user     string
+	currency string
+	key      string
+	amount   money.Amount
+	status   modules.HoldStatus
+	expireAt time.Time
+}
+
+// NewMemoryStore ...
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		balances:   make(map[string]money.Amount),
+		requestIDs: make(map[string]time.Time),
+		outcomes:   make(map[string]*Outcome),
+		rollbacks:  make(map[string]string),
+		held:       make(map[string]money.Amount),
+		holds:      make(map[string]*memHold),
+		entries:    make(map[string][]modules.JournalLine),
+	}
+}
+
+// UseMemory run every backend on one in process store
+func UseMemory() *MemoryStore {
+	m := NewMemoryStore()
+	for name := range stores {
+		stores[name] = m
+	}
+	stores[MemoryBackend] = m
+	return m
+}
+
+// Memory in process store if enabled
+func Memory() *MemoryStore {
+	m, _ := stores[MemoryBackend].(*MemoryStore)
+	return m
+}
+
+// SetBalance create or overwrite user wallet of currency
+func (m *MemoryStore) SetBalance(agent, user, currency string, amount money.Amount) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := BuildRedisDataWithDelimiter(agent, user, currency)
+	m.post("", ledger.Opening(ledger.Wallet(agent, user, currency), currency, amount-m.balances[key]))
+	m.balances[key] = amount
+}
+
+// Journal copy of posted ledger lines
+func (m *MemoryStore) Journal() []modules.JournalLine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]modules.JournalLine(nil), m.journal...)
+}
+
+// LedgerBalance account balance derived from journal
+func (m *MemoryStore) LedgerBalance(account string) money.Amount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := money.Amount(0)
+	for _, l := range m.journal {
+		if l.Account == account {
+			b += l.Amount
+		}
+	}
+	return b
+}
+
+// Orders copy of recorded orders
+func (m *MemoryStore) Orders() []Operation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Operation(nil), m.orders...)
+}
+
+// SearchOrders ...
+func (m *MemoryStore) SearchOrders(q *OrderQuery) ([]*OrderRecord, error) {
+	return appendOrders(nil, m.findOrders(q), q.Limit+1), nil
+}
+
+// ExportOrders ...
+func (m *MemoryStore) ExportOrders(q *OrderQuery, fn func(*OrderRecord) error) error {
+	list := m.findOrders(q)
+	for i := len(list) - 1; i >= 0; i-- {
+		if err := fn(list[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findOrders orders matching query and its cursor, newest first
+func (m *MemoryStore) findOrders(q *OrderQuery) []*OrderRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []*OrderRecord{}
+	for i := range m.orders {
+		op := &m.orders[i]
+		o := &OrderRecord{
+			OrderID:      op.OrderID,
+			Agent:        op.Agent,
+			User:         op.User,
+			Currency:     op.Currency,
+			OpType:       op.OpType,
+			RequestID:    op.RequestID,
+			GameID:       op.GameID,
+			RefOrderID:   op.RefOrderID,
+			Status:       modules.OrderOk,
+			BeforeAmount: op.BeforeAmount,
+			AfterAmount:  op.AfterAmount,
+			FXCurrency:   op.FXCurrency,
+			FXAmount:     op.FXAmount,
+			Rate:         op.Rate,
+			CreateAt:     op.UpdateAt,
+		}
+		if m.rollbacks[op.OrderID] != "" {
+			o.Status = modules.OrderRollback
+		}
+		if q.match(o) && q.matchStatus(o) && q.after(o) {
+			list = append(list, o)
+		}
+	}
+
+	return appendOrders(nil, list, len(list))
+}
+
+// GetBalance ...
+func (m *MemoryStore) GetBalance(agent, user, currency string) (money.Amount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.balances[BuildRedisDataWithDelimiter(agent, user, currency)]
+	if !ok {
+		return 0, m.missing(agent, user)
+	}
+
+	return b, nil
+}
+
+// Balance ...
+func (m *MemoryStore) Balance(agent, user, currency string) (*Balance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := BuildRedisDataWithDelimiter(agent, user, currency)
+	amount, ok := m.balances[key]
+	if !ok {
+		return nil, m.missing(agent, user)
+	}
+
+	b := &Balance{Agent: agent, User: user, Currency: currency, Amount: amount, Held: m.held[key]}
+	for i := len(m.orders) - 1; i >= 0; i-- {
+		o := m.orders[i]
+		if o.Agent != agent || o.User != user || o.Currency != currency {
+			continue
+		}
+		if b.LastChange.IsZero() {
+			b.LastChange = o.UpdateAt
+		}
+		if o.OpType == modules.WalletDeduct {
+			b.LastGameID = o.GameID
+			break
+		}
+	}
+
+	return b, nil
+}
+
+// ApplyDelta ...
+func (m *MemoryStore) ApplyDelta(op *Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.useRequestID(op.Agent, op.RequestID); err != nil {
+		return err
+	}
+
+	key := BuildRedisDataWithDelimiter(op.Agent, op.User, op.Currency)
+	before, ok := m.balances[key]
+	if !ok {
+		return m.missing(op.Agent, op.User)
+	}
+
+	after := before + op.Delta()
+	if after < 0 {
+		return ErrNotEnoughBalance
+	}
+	m.balances[key] = after
+
+	op.OrderID = guuid.New().String()
+	op.BeforeAmount = before
+	op.AfterAmount = after
+	op.UpdateAt = time.Now()
+
+	m.post(op.OrderID, op.Lines())
+	m.orders = append(m.orders, *op)
+
+	return nil
+}
+
+// Rollback ...
+func (m *MemoryStore) Rollback(op *Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.useRequestID(op.Agent, op.RequestID); err != nil {
+		return err
+	}
+
+	key := BuildRedisDataWithDelimiter(op.Agent, op.User, op.Currency)
+	before, ok := m.balances[key]
+	if !ok {
+		return m.missing(op.Agent, op.User)
+	}
+
+	// ref order must be of same wallet, as pg find it by wallet id
+	var ref *Operation
+	for i := range m.orders {
+		o := &m.orders[i]
+		if o.Agent != op.Agent || o.User != op.User || o.Currency != op.Currency {
+			continue
+		}
+		if (op.RefOrderID != "" && o.OrderID == op.RefOrderID) ||
+			(op.RefOrderID == "" && o.RequestID == op.RefRequestID) {
+			ref = o
+			break
+		}
+	}
+
+	switch {
+	case ref == nil:
+		return ErrOrderNotFound
+	case m.rollbacks[ref.OrderID] != "":
+		return ErrAlreadyRollback
+	case !Rollbackable(ref.OpType):
+		return ErrNotRollbackable
+	case ref.Amount != op.Amount:
+		return ErrRollbackAmount
+	}
+
+	after := before - ref.Delta()
+	if after < 0 {
+		return ErrNotEnoughBalance
+	}
+	m.balances[key] = after
+
+	op.OrderID = guuid.New().String()
+	op.RefOrderID = ref.OrderID
+	op.BeforeAmount = before
+	op.AfterAmount = after
+	op.UpdateAt = time.Now()
+
+	m.rollbacks[ref.OrderID] = op.OrderID
+	m.post(op.OrderID, ledger.Reverse(m.entries[ref.OrderID]))
+	m.orders = append(m.orders, *op)
+
+	return nil
+}
+
+// Transfer ...
+func (m *MemoryStore) Transfer(op *Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.useRequestID(op.Agent, op.RequestID); err != nil {
+		return err
+	}
+
+	toCurrency, toAmount := op.Credit()
+	fromKey := BuildRedisDataWithDelimiter(op.Agent, op.User, op.Currency)
+	toKey := BuildRedisDataWithDelimiter(op.Agent, op.ToUser, toCurrency)
+
+	fromBefore, ok := m.balances[fromKey]
+	if !ok {
+		return m.missing(op.Agent, op.User)
+	}
+	toBefore, ok := m.balances[toKey]
+	if !ok {
+		return m.missing(op.Agent, op.ToUser)
+	}
+
+	if fromBefore < op.Amount {
+		return ErrNotEnoughBalance
+	}
+
+	m.balances[fromKey] = fromBefore - op.Amount
+	m.balances[toKey] = toBefore + toAmount
+
+	op.OrderID = guuid.New().String()
+	op.ToOrderID = guuid.New().String()
+	op.RefOrderID = op.ToOrderID
+	op.BeforeAmount = fromBefore
+	op.AfterAmount = fromBefore - op.Amount
+	op.ToBeforeAmount = toBefore
+	op.ToAfterAmount = toBefore + toAmount
+	op.UpdateAt = time.Now()
+
+	in := *op
+	in.User = op.ToUser
+	in.OpType = modules.WalletTransferIn
+	in.Currency, in.Amount = toCurrency, toAmount
+	if op.Rate != 0 {
+		in.FXCurrency, in.FXAmount = op.Currency, op.Amount
+	}
+	in.OrderID = op.ToOrderID
+	in.RefOrderID = op.OrderID
+	in.BeforeAmount = op.ToBeforeAmount
+	in.AfterAmount = op.ToAfterAmount
+
+	m.post(op.OrderID, op.Lines())
+	m.orders = append(m.orders, *op, in)
+
+	return nil
+}
+
+// Hold ...
+func (m *MemoryStore) Hold(op *Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.useRequestID(op.Agent, op.RequestID); err != nil {
+		return err
+	}
+
+	key := BuildRedisDataWithDelimiter(op.Agent, op.User, op.Currency)
+	before, ok := m.balances[key]
+	if !ok {
+		return m.missing(op.Agent, op.User)
+	}
+	if before < op.Amount {
+		return ErrNotEnoughBalance
+	}
+
+	m.balances[key] = before - op.Amount
+	m.held[key] += op.Amount
+
+	op.OrderID = guuid.New().String()
+	op.HoldID = op.OrderID
+	op.BeforeAmount = before
+	op.AfterAmount = before - op.Amount
+	op.UpdateAt = time.Now()
+
+	m.holds[op.HoldID] = &memHold{agent: op.Agent, user: op.User, currency: op.Currency, key: key, amount: op.Amount, status: modules.HoldHeld, expireAt: *op.ExpireAt}
+	m.post(op.OrderID, op.Lines())
+	m.orders = append(m.orders, *op)
+
+	return nil
+}
+
+// Settle ...
+func (m *MemoryStore) Settle(op *Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.useRequestID(op.Agent, op.RequestID); err != nil {
+		return err
+	}
+
+	key := BuildRedisDataWithDelimiter(op.Agent, op.User, op.Currency)
+	h, ok := m.holds[op.HoldID]
+
+	captured := money.Amount(0)
+	status := modules.HoldReleased
+
+	switch {
+	case !ok || h.key != key:
+		return ErrHoldNotFound
+	case h.status != modules.HoldHeld:
+		return ErrHoldSettled
+	case !time.Now().Before(h.expireAt):
+		return ErrHoldExpired
+	case op.OpType == modules.WalletCapture:
+		captured = op.Amount
+		status = modules.HoldCaptured
+		if captured > h.amount {
+			return ErrHoldAmount
+		}
+	case op.Amount != h.amount:
+		return ErrHoldAmount
+	}
+
+	m.settle(op, h, status, captured)
+	m.post(op.OrderID, op.Lines())
+	m.orders = append(m.orders, *op)
+
+	return nil
+}
+
+// ExpireHolds ...
+func (m *MemoryStore) ExpireHolds(limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	now := time.Now()
+	for id, h := range m.holds {
+		if n >= limit {
+			break
+		}
+		if h.status != modules.HoldHeld || now.Before(h.expireAt) {
+			continue
+		}
+
+		op := &Operation{Agent: h.agent, User: h.user, Currency: h.currency, OpType: modules.WalletRelease, HoldID: id, Amount: h.amount}
+		m.settle(op, h, modules.HoldExpired, 0)
+		m.post(op.OrderID, op.Lines())
+		m.orders = append(m.orders, *op)
+		n++
+	}
+
+	return n, nil
+}
+
+func (m *MemoryStore) settle(op *Operation, h *memHold, status modules.HoldStatus, captured money.Amount) {
+	before := m.balances[h.key]
+	after := before + h.amount - captured
+
+	m.balances[h.key] = after
+	m.held[h.key] -= h.amount
+	h.status = status
+
+	op.OrderID = guuid.New().String()
+	op.RefOrderID = op.HoldID
+	op.BeforeAmount = before
+	op.AfterAmount = after
+	op.UpdateAt = time.Now()
+}
+
+// post keep balanced lines of order, panic on unbalanced since it is a bug
+// of Lines and not of request
+func (m *MemoryStore) post(orderID string, lines []modules.JournalLine) {
+	if err := ledger.Balanced(lines); err != nil {
+		panic(err)
+	}
+
+	for _, l := range lines {
+		if l.Amount == 0 {
+			continue
+		}
+		m.journal = append(m.journal, l)
+		if orderID != "" {
+			m.entries[orderID] = append(m.entries[orderID], l)
+		}
+	}
+}
+
+// missing error for wallet not found, user may still have other currency
+func (m *MemoryStore) missing(agent, user string) error {
+	for _, c := range money.Currencies() {
+		if _, ok := m.balances[BuildRedisDataWithDelimiter(agent, user, c)]; ok {
+			return ErrCurrencyMismatch
+		}
+	}
+	return ErrUserNotFound
+}
+
+// useRequestID mark agent request id till its ttl, requestIDs keep expire
+// time
+func (m *MemoryStore) useRequestID(agent, id string) error {
+	now := time.Now()
+	key := BuildRedisDataWithDelimiter(agent, id)
+
+	if t, ok := m.requestIDs[key]; ok && now.Before(t) {
+		return ErrRequestIDRepeat
+	}
+	m.requestIDs[key] = now.Add(requestIDTTL(agent))
+	delete(m.outcomes, key)
+
+	return nil
+}
+
+// SaveOutcome ...
+func (m *MemoryStore) SaveOutcome(o *Outcome) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := *o
+	op := *o.Op
+	c.Op = &op
+	m.outcomes[BuildRedisDataWithDelimiter(o.Agent, o.RequestID)] = &c
+
+	return nil
+}
+
+// FindOutcome ...
+func (m *MemoryStore) FindOutcome(agent, requestID string) (*Outcome, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.outcomes[BuildRedisDataWithDelimiter(agent, requestID)]
+	if !ok {
+		return nil, nil
+	}
+
+	c := *o
+	op := *o.Op
+	c.Op = &op
+	return &c, nil
+}
+
+// ExpireRequestIDs drop request ids over agent ttl, with their outcome
+func (m *MemoryStore) ExpireRequestIDs() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, t := range m.requestIDs {
+		if !now.Before(t) {
+			delete(m.requestIDs, key)
+			delete(m.outcomes, key)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package wallet
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/kyos0109/test-wallet/agent"
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/fx"
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	kredis "github.com/kyos0109/test-wallet/redis"
+	"github.com/kyos0109/test-wallet/session"
+)
+
+const (
+	testAgent = "100"
+	// testShortAgent agent whose request id expire at once
+	testShortAgent = "101"
+	// testAgentUser user holding agent wallets of testAgent
+	testAgentUser = "0"
+)
+
+var testRedis *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	testRedis = miniredis.NewMiniRedis()
+	if err := testRedis.Start(); err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+	kredis.InitWithCtx(&ctx)
+	rc := config.Default().Redis
+	rc.Addr = testRedis.Addr()
+	kredis.InitWithConfig(&rc)
+
+	session.InitWithConfig(&config.SessionConfig{Backend: session.MemoryBackend, TTL: time.Hour})
+	agent.InitWithConfig(&config.AgentConfig{Source: agent.MemorySource, ReloadInterval: time.Minute})
+	fx.InitWithConfig(&config.FXConfig{Source: fx.MemorySource, BaseCurrency: "USD", ReloadInterval: time.Minute})
+
+	c := config.Default().Wallet
+	c.AgentRequestIDTTL = map[string]time.Duration{testShortAgent: time.Millisecond}
+	InitWithConfig(&c)
+
+	if _, err := agent.Create(&modules.PostAgent{ID: 100, Name: "test", WalletUser: testAgentUser}); err != nil {
+		panic(err)
+	}
+	rate, _ := money.ParseRate("30")
+	if err := fx.Update(&modules.FXRate{FromCurrency: "USD", ToCurrency: "TWD", Rate: rate}); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	testRedis.Close()
+	os.Exit(code)
+}
+
+// testPost post data of testAgent user with a fresh player token
+func testPost(t *testing.T, user, currency, requestID string, amount money.Amount, detail interface{}) *modules.PostDatav2 {
+	return testAgentPost(t, testAgent, user, currency, requestID, amount, detail)
+}
+
+func testAgentPost(t *testing.T, agentID, user, currency, requestID string, amount money.Amount, detail interface{}) *modules.PostDatav2 {
+	t.Helper()
+
+	tok, err := session.Issue(agentID, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &modules.PostDatav2{
+		Agent:     agentID,
+		User:      user,
+		Currency:  currency,
+		RequestID: requestID,
+		Amount:    amount,
+		Token:     tok.Token,
+		Detail:    detail,
+	}
+}
+
+// checkWallet wallet balance and held, and ledger matching both
+func checkWallet(t *testing.T, m *MemoryStore, user, currency string, amount, held money.Amount) {
+	t.Helper()
+
+	b, err := m.Balance(testAgent, user, currency)
+	if err != nil {
+		t.Fatalf("balance of %s %s: %v", user, currency, err)
+	}
+	if b.Amount != amount || b.Held != held {
+		t.Errorf("wallet %s %s is %s held %s, want %s held %s", user, currency, b.Amount, b.Held, amount, held)
+	}
+
+	if l := m.LedgerBalance(ledger.Wallet(testAgent, user, currency)); l != b.Amount {
+		t.Errorf("ledger wallet %s %s is %s, wallet %s", user, currency, l, b.Amount)
+	}
+	if l := m.LedgerBalance(ledger.Held(testAgent, user, currency)); l != b.Held {
+		t.Errorf("ledger held %s %s is %s, held %s", user, currency, l, b.Held)
+	}
+}
+
+func TestMemoryApplyDelta(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		currency   string
+		amount     money.Amount
+		detail     interface{}
+		wantErr    error
+		wantStatus int
+		want       money.Amount
+	}{
+		{"store", "1", "TWD", money.FromInt(50), &modules.PostStorev2{}, nil, http.StatusOK, money.FromInt(150)},
+		{"deduct", "1", "TWD", money.FromInt(30), &modules.PostDeductv2{GameID: 1}, nil, http.StatusOK, money.FromInt(70)},
+		{"deduct all", "1", "TWD", money.FromInt(100), &modules.PostDeductv2{GameID: 1}, nil, http.StatusOK, 0},
+		{"deduct over balance", "1", "TWD", money.FromInt(101), &modules.PostDeductv2{GameID: 1}, ErrNotEnoughBalance, http.StatusConflict, money.FromInt(100)},
+		{"deduct in wallet currency", "1", "USD", money.FromInt(1), &modules.PostDeductv2{GameID: 1, WalletCurrency: "TWD"}, nil, http.StatusOK, money.FromInt(70)},
+		{"no rate", "1", "JPY", money.FromInt(1), &modules.PostStorev2{WalletCurrency: "TWD"}, ErrNoRate, http.StatusBadRequest, money.FromInt(100)},
+		{"over precision", "1", "TWD", money.Amount(1), &modules.PostStorev2{}, ErrAmountPrecision, http.StatusBadRequest, money.FromInt(100)},
+		{"zero amount", "1", "TWD", 0, &modules.PostStorev2{}, ErrInvalidAmount, http.StatusBadRequest, money.FromInt(100)},
+		{"unknown user", "2", "TWD", money.FromInt(1), &modules.PostStorev2{}, ErrUserNotFound, http.StatusNotFound, money.FromInt(100)},
+		{"other currency of user", "1", "EUR", money.FromInt(1), &modules.PostStorev2{}, ErrCurrencyMismatch, http.StatusBadRequest, money.FromInt(100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryStore()
+			m.SetBalance(testAgent, "1", "TWD", money.FromInt(100))
+
+			op, status, err := Process(m, testPost(t, tt.user, tt.currency, "req", tt.amount, tt.detail))
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status %d, want %d", status, tt.wantStatus)
+			}
+			if err == nil && op.AfterAmount != tt.want {
+				t.Errorf("after %s, want %s", op.AfterAmount, tt.want)
+			}
+			checkWallet(t, m, "1", "TWD", tt.want, 0)
+		})
+	}
+}
+
+func TestMemoryRequestID(t *testing.T) {
+	tests := []struct {
+		name       string
+		agent      string
+		first      money.Amount
+		again      money.Amount
+		wait       time.Duration
+		wantErr    error
+		wantStatus int
+		wantReplay bool
+		want       money.Amount
+	}{
+		{"replay same request", testAgent, money.FromInt(30), money.FromInt(30), 0, nil, http.StatusOK, true, money.FromInt(70)},
+		{"other request reuse id", testAgent, money.FromInt(30), money.FromInt(40), 0, ErrRequestIDConflict, http.StatusConflict, false, money.FromInt(70)},
+		{"replay failed request", testAgent, money.FromInt(200), money.FromInt(200), 0, ErrNotEnoughBalance, http.StatusConflict, true, money.FromInt(100)},
+		{"run again after ttl", testShortAgent, money.FromInt(30), money.FromInt(30), 5 * time.Millisecond, nil, http.StatusOK, false, money.FromInt(40)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryStore()
+			m.SetBalance(tt.agent, "1", "TWD", money.FromInt(100))
+
+			first, _, _ := Process(m, testAgentPost(t, tt.agent, "1", "TWD", "req", tt.first, &modules.PostDeductv2{GameID: 1}))
+			time.Sleep(tt.wait)
+
+			op, status, err := Process(m, testAgentPost(t, tt.agent, "1", "TWD", "req", tt.again, &modules.PostDeductv2{GameID: 1}))
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status %d, want %d", status, tt.wantStatus)
+			}
+			if op.Replay != tt.wantReplay {
+				t.Errorf("replay %v, want %v", op.Replay, tt.wantReplay)
+			}
+			if tt.wantReplay && op.OrderID != first.OrderID {
+				t.Errorf("replay order %s, want %s", op.OrderID, first.OrderID)
+			}
+
+			b, err := m.GetBalance(tt.agent, "1", "TWD")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b != tt.want {
+				t.Errorf("balance %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		ref       string
+		byOrderID bool
+		amount    money.Amount
+		spend     money.Amount
+		twice     bool
+		wantErr   error
+		want      money.Amount
+	}{
+		{"deduct by request id", "deduct", false, money.FromInt(30), 0, false, nil, money.FromInt(110)},
+		{"deduct by order id", "deduct", true, money.FromInt(30), 0, false, nil, money.FromInt(110)},
+		{"store", "store", false, money.FromInt(20), 0, false, nil, money.FromInt(60)},
+		{"store already spent", "store", false, money.FromInt(20), money.FromInt(70), false, ErrNotEnoughBalance, money.FromInt(10)},
+		{"twice", "deduct", false, money.FromInt(30), 0, true, ErrAlreadyRollback, money.FromInt(110)},
+		{"amount not match", "deduct", false, money.FromInt(20), 0, false, ErrRollbackAmount, money.FromInt(80)},
+		{"hold", "hold", false, money.FromInt(10), 0, false, ErrNotRollbackable, money.FromInt(80)},
+		{"order of other currency", "usd", false, money.FromInt(5), 0, false, ErrOrderNotFound, money.FromInt(80)},
+		{"unknown order", "none", false, money.FromInt(30), 0, false, ErrOrderNotFound, money.FromInt(80)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryStore()
+			m.SetBalance(testAgent, "1", "TWD", money.FromInt(100))
+			m.SetBalance(testAgent, "1", "USD", money.FromInt(100))
+
+			ops := map[string]*Operation{}
+			for _, p := range []*modules.PostDatav2{
+				testPost(t, "1", "TWD", "deduct", money.FromInt(30), &modules.PostDeductv2{GameID: 1}),
+				testPost(t, "1", "TWD", "store", money.FromInt(20), &modules.PostStorev2{}),
+				testPost(t, "1", "TWD", "hold", money.FromInt(10), &modules.PostHoldv2{GameID: 1}),
+				testPost(t, "1", "USD", "usd", money.FromInt(5), &modules.PostStorev2{}),
+			} {
+				op, _, err := Process(m, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ops[p.RequestID] = op
+			}
+			// wallet 100 - 30 + 20 - 10 held
+			if tt.spend > 0 {
+				if _, _, err := Process(m, testPost(t, "1", "TWD", "spend", tt.spend, &modules.PostDeductv2{GameID: 1})); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := &modules.PostRollbackv2{RefRequestID: tt.ref}
+			if tt.byOrderID {
+				d = &modules.PostRollbackv2{RefOrderID: ops[tt.ref].OrderID}
+			}
+			if tt.twice {
+				if _, _, err := Process(m, testPost(t, "1", "TWD", "rollback first", tt.amount, d)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			op, _, err := Process(m, testPost(t, "1", "TWD", "rollback", tt.amount, d))
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && op.RefOrderID != ops[tt.ref].OrderID {
+				t.Errorf("ref order %s, want %s", op.RefOrderID, ops[tt.ref].OrderID)
+			}
+			checkWallet(t, m, "1", "TWD", tt.want, money.FromInt(10))
+			checkWallet(t, m, "1", "USD", money.FromInt(105), 0)
+		})
+	}
+}
+
+func TestMemoryTransfer(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  money.Amount
+		detail  *modules.PostTransferv2
+		wantErr error
+		want    map[string]money.Amount
+	}{
+		{"to user", money.FromInt(40), &modules.PostTransferv2{ToUser: "2"}, nil,
+			map[string]money.Amount{"1:TWD": money.FromInt(60), "2:TWD": money.FromInt(40)}},
+		{"over balance", money.FromInt(140), &modules.PostTransferv2{ToUser: "2"}, ErrNotEnoughBalance,
+			map[string]money.Amount{"1:TWD": money.FromInt(100), "2:TWD": 0}},
+		{"exchange own wallets", money.FromInt(60), &modules.PostTransferv2{ToUser: "1", ToCurrency: "USD"}, nil,
+			map[string]money.Amount{"1:TWD": money.FromInt(40), "1:USD": money.FromInt(2)}},
+		{"to self", money.FromInt(10), &modules.PostTransferv2{ToUser: "1"}, ErrTransferSelf,
+			map[string]money.Amount{"1:TWD": money.FromInt(100)}},
+		{"no to user", money.FromInt(10), &modules.PostTransferv2{}, ErrTransferTo,
+			map[string]money.Amount{"1:TWD": money.FromInt(100)}},
+		{"to user without wallet", money.FromInt(10), &modules.PostTransferv2{ToUser: "3"}, ErrUserNotFound,
+			map[string]money.Amount{"1:TWD": money.FromInt(100)}},
+		{"from agent", money.FromInt(30), &modules.PostTransferv2{FromAgent: true}, nil,
+			map[string]money.Amount{"1:TWD": money.FromInt(130), testAgentUser + ":TWD": money.FromInt(970)}},
+		{"from agent to other user", money.FromInt(30), &modules.PostTransferv2{FromAgent: true, ToUser: "2"}, ErrTransferTo,
+			map[string]money.Amount{"1:TWD": money.FromInt(100), testAgentUser + ":TWD": money.FromInt(1000)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryStore()
+			m.SetBalance(testAgent, "1", "TWD", money.FromInt(100))
+			m.SetBalance(testAgent, "1", "USD", 0)
+			m.SetBalance(testAgent, "2", "TWD", 0)
+			m.SetBalance(testAgent, testAgentUser, "TWD", money.FromInt(1000))
+
+			_, _, err := Process(m, testPost(t, "1", "TWD", "req", tt.amount, tt.detail))
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+
+			for w, amount := range tt.want {
+				user, currency := w[:len(w)-4], w[len(w)-3:]
+				checkWallet(t, m, user, currency, amount, 0)
+			}
+		})
+	}
+}
+
+func TestMemoryHold(t *testing.T) {
+	type settle struct {
+		opType  modules.WalletOps
+		amount  money.Amount
+		user    string
+		wantErr error
+	}
+
+	tests := []struct {
+		name     string
+		hold     money.Amount
+		expire   bool
+		settles  []settle
+		wantErr  error
+		want     money.Amount
+		wantHeld money.Amount
+	}{
+		{"held", money.FromInt(30), false, nil, nil, money.FromInt(70), money.FromInt(30)},
+		{"over balance", money.FromInt(101), false, nil, ErrNotEnoughBalance, money.FromInt(100), 0},
+		{"capture part", money.FromInt(30), false, []settle{
+			{modules.WalletCapture, money.FromInt(20), "1", nil},
+		}, nil, money.FromInt(80), 0},
+		{"capture over hold", money.FromInt(30), false, []settle{
+			{modules.WalletCapture, money.FromInt(40), "1", ErrHoldAmount},
+		}, nil, money.FromInt(70), money.FromInt(30)},
+		{"release", money.FromInt(30), false, []settle{
+			{modules.WalletRelease, money.FromInt(30), "1", nil},
+		}, nil, money.FromInt(100), 0},
+		{"release part", money.FromInt(30), false, []settle{
+			{modules.WalletRelease, money.FromInt(20), "1", ErrHoldAmount},
+		}, nil, money.FromInt(70), money.FromInt(30)},
+		{"settle twice", money.FromInt(30), false, []settle{
+			{modules.WalletCapture, money.FromInt(30), "1", nil},
+			{modules.WalletRelease, money.FromInt(30), "1", ErrHoldSettled},
+		}, nil, money.FromInt(70), 0},
+		{"hold of other user", money.FromInt(30), false, []settle{
+			{modules.WalletCapture, money.FromInt(30), "2", ErrHoldNotFound},
+		}, nil, money.FromInt(70), money.FromInt(30)},
+		{"expired", money.FromInt(30), true, []settle{
+			{modules.WalletCapture, money.FromInt(30), "1", ErrHoldExpired},
+		}, nil, money.FromInt(70), money.FromInt(30)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemoryStore()
+			m.SetBalance(testAgent, "1", "TWD", money.FromInt(100))
+			m.SetBalance(testAgent, "2", "TWD", money.FromInt(100))
+
+			h, _, err := Process(m, testPost(t, "1", "TWD", "hold", tt.hold, &modules.PostHoldv2{GameID: 1}))
+			if err != tt.wantErr {
+				t.Fatalf("hold err %v, want %v", err, tt.wantErr)
+			}
+			if tt.expire {
+				m.holds[h.HoldID].expireAt = time.Now()
+			}
+
+			for i, s := range tt.settles {
+				var d interface{} = &modules.PostCapturev2{HoldID: h.HoldID}
+				if s.opType == modules.WalletRelease {
+					d = &modules.PostReleasev2{HoldID: h.HoldID}
+				}
+				id := "settle " + string(rune('a'+i))
+				if _, _, err := Process(m, testPost(t, s.user, "TWD", id, s.amount, d)); err != s.wantErr {
+					t.Fatalf("%s err %v, want %v", s.opType, err, s.wantErr)
+				}
+			}
+			checkWallet(t, m, "1", "TWD", tt.want, tt.wantHeld)
+		})
+	}
+}
+
+func TestMemoryExpireHolds(t *testing.T) {
+	m := NewMemoryStore()
+	m.SetBalance(testAgent, "1", "TWD", money.FromInt(100))
+
+	var holds []*Operation
+	for _, id := range []string{"a", "b", "c"} {
+		h, _, err := Process(m, testPost(t, "1", "TWD", id, money.FromInt(10), &modules.PostHoldv2{GameID: 1}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		holds = append(holds, h)
+	}
+	m.holds[holds[0].HoldID].expireAt = time.Now()
+	m.holds[holds[1].HoldID].expireAt = time.Now()
+
+	for _, want := range []int{1, 1, 0} {
+		n, err := m.ExpireHolds(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("expired %d, want %d", n, want)
+		}
+	}
+	checkWallet(t, m, "1", "TWD", money.FromInt(90), money.FromInt(10))
+
+	_, _, err := Process(m, testPost(t, "1", "TWD", "release", money.FromInt(10), &modules.PostReleasev2{HoldID: holds[0].HoldID}))
+	if err != ErrHoldSettled {
+		t.Errorf("release expired hold err %v, want %v", err, ErrHoldSettled)
+	}
+}
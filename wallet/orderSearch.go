@@ -0,0 +1,180 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// order search page size
+const (
+	orderPageDefault = 50
+	orderPageMax     = 500
+)
+
+// order search error
+var (
+	ErrOrderQuery = errcode.New(errcode.OrderQuery, http.StatusBadRequest, "order query need agent")
+	ErrCursor     = errcode.New(errcode.InvalidCursor, http.StatusBadRequest, "invalid cursor")
+)
+
+// OrderQuery filter of order search, empty field match all. From is
+// inclusive and To exclusive on create time, Cursor is Next of last page
+type OrderQuery struct {
+	Agent     string
+	User      string
+	Currency  string
+	From      time.Time
+	To        time.Time
+	OpType    modules.WalletOps
+	GameID    int
+	Status    modules.OrderStatus
+	RequestID string
+	Cursor    string
+	Limit     int
+
+	// position of cursor, search return orders after it
+	afterTime time.Time
+	afterID   string
+}
+
+// OrderRecord one order of search, same shape on every backend
+type OrderRecord struct {
+	OrderID      string              `json:"orderid"`
+	Agent        string              `json:"agent"`
+	User         string              `json:"user"`
+	Currency     string              `json:"currency"`
+	OpType       modules.WalletOps   `json:"optype"`
+	RequestID    string              `json:"requestid"`
+	GameID       int                 `json:"gameid,omitempty"`
+	RefOrderID   string              `json:"reforderid,omitempty"`
+	Status       modules.OrderStatus `json:"status"`
+	BeforeAmount money.Amount        `json:"before"`
+	AfterAmount  money.Amount        `json:"after"`
+	FXCurrency   string              `json:"fxcurrency,omitempty"`
+	FXAmount     money.Amount        `json:"fxamount,omitempty"`
+	Rate         money.Rate          `json:"rate,omitempty"`
+	CreateAt     time.Time           `json:"createat"`
+}
+
+// OrderPage orders newest first, Next empty on last page
+type OrderPage struct {
+	Orders []*OrderRecord `json:"orders"`
+	Next   string         `json:"next,omitempty"`
+}
+
+// SearchOrders one page of orders matching query on store, order by create
+// time then order id, both descending, so cursor stay stable while new
+// orders come in
+func SearchOrders(s Store, q *OrderQuery) (*OrderPage, error) {
+	if q.Agent == "" {
+		return nil, ErrOrderQuery
+	}
+	if q.Limit <= 0 {
+		q.Limit = orderPageDefault
+	}
+	if q.Limit > orderPageMax {
+		q.Limit = orderPageMax
+	}
+	if q.Cursor != "" {
+		t, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.afterTime, q.afterID = t, id
+	}
+
+	// one more tell if there is next page
+	list, err := s.SearchOrders(q)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &OrderPage{Orders: list}
+	if len(list) > q.Limit {
+		page.Orders = list[:q.Limit]
+		last := page.Orders[q.Limit-1]
+		page.Next = encodeCursor(last.CreateAt, last.OrderID)
+	}
+
+	return page, nil
+}
+
+// match filter of query except status and cursor
+func (q *OrderQuery) match(o *OrderRecord) bool {
+	switch {
+	case o.Agent != q.Agent:
+	case q.User != "" && o.User != q.User:
+	case q.Currency != "" && o.Currency != q.Currency:
+	case !q.From.IsZero() && o.CreateAt.Before(q.From):
+	case !q.To.IsZero() && !o.CreateAt.Before(q.To):
+	case q.OpType != "" && o.OpType != q.OpType:
+	case q.GameID != 0 && o.GameID != q.GameID:
+	case q.RequestID != "" && o.RequestID != q.RequestID:
+	default:
+		return true
+	}
+	return false
+}
+
+// matchStatus status filter of query
+func (q *OrderQuery) matchStatus(o *OrderRecord) bool {
+	return q.Status == "" || o.Status == q.Status
+}
+
+// after true when order come after cursor in search order
+func (q *OrderQuery) after(o *OrderRecord) bool {
+	if q.afterID == "" {
+		return true
+	}
+	return orderBefore(q.afterTime, q.afterID, o)
+}
+
+// orderBefore true when order sort after position t, id
+func orderBefore(t time.Time, id string, o *OrderRecord) bool {
+	if !o.CreateAt.Equal(t) {
+		return o.CreateAt.Before(t)
+	}
+	return o.OrderID < id
+}
+
+// appendOrders merge more into list in search order, keep at most max
+func appendOrders(list, more []*OrderRecord, max int) []*OrderRecord {
+	list = append(list, more...)
+	sort.Slice(list, func(i, j int) bool {
+		return orderBefore(list[i].CreateAt, list[i].OrderID, list[j])
+	})
+	if len(list) > max {
+		list = list[:max]
+	}
+	return list
+}
+
+func encodeCursor(t time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(t.UnixNano(), 10) + ":" + id))
+}
+
+func decodeCursor(c string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return time.Time{}, "", ErrCursor
+	}
+
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", ErrCursor
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", ErrCursor
+	}
+
+	return time.Unix(0, ns), parts[1], nil
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// Outcome result of one request id, kept so a retry of same request get
+// same answer. Fingerprint tell retry from other payload reuse the id
+type Outcome struct {
+	Agent       string     `json:"agent"`
+	RequestID   string     `json:"requestid"`
+	Fingerprint string     `json:"fingerprint"`
+	Status      int        `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Op          *Operation `json:"op"`
+}
+
+// request id error of replay
+var (
+	ErrRequestIDConflict = errcode.New(errcode.RequestIDConflict, http.StatusConflict, "request id used by other request")
+	ErrRequestPending    = errcode.New(errcode.RequestPending, http.StatusConflict, "request id in use, result not ready")
+)
+
+// fingerprint hash of what request ask, not who send it or when
+func fingerprint(op *Operation) string {
+	p := op.post
+	j, _ := json.Marshal(struct {
+		Agent    string            `json:"agent"`
+		User     string            `json:"user"`
+		OpType   modules.WalletOps `json:"optype"`
+		Amount   money.Amount      `json:"amount"`
+		Currency string            `json:"currency"`
+		Detail   interface{}       `json:"detail"`
+	}{p.Agent, p.User, op.OpType, p.Amount, p.Currency, p.Detail})
+
+	sum := sha256.Sum256(j)
+	return hex.EncodeToString(sum[:])
+}
+
+// keepOutcome save op result for replay. 5xx and bad request id are not
+// kept, store did not finish with the id. outcome store kept with the
+// wallet change not saved again
+func keepOutcome(s Store, op *Operation, status int, err error) {
+	if op.kept || status >= http.StatusInternalServerError || err == ErrInvalidRequestID {
+		return
+	}
+
+	if err := s.SaveOutcome(newOutcome(op, status, err)); err != nil {
+		log.Println("save outcome of", op.RequestID, err)
+	}
+}
+
+// newOutcome outcome of op finished with status and err
+func newOutcome(op *Operation, status int, err error) *Outcome {
+	o := &Outcome{
+		Agent:       op.Agent,
+		RequestID:   op.RequestID,
+		Fingerprint: fingerprint(op),
+		Status:      status,
+		Op:          op,
+	}
+	if err != nil {
+		o.Error = err.Error()
+	}
+
+	return o
+}
+
+// replay answer repeat request id with kept outcome, if same request
+func replay(s Store, op *Operation) (*Operation, int, error) {
+	o, err := s.FindOutcome(op.Agent, op.RequestID)
+	if err != nil {
+		return op, StatusOf(err), err
+	}
+	if o == nil {
+		return op, StatusOf(ErrRequestPending), ErrRequestPending
+	}
+	if o.Fingerprint != fingerprint(op) {
+		return op, StatusOf(ErrRequestIDConflict), ErrRequestIDConflict
+	}
+
+	o.Op.Replay = true
+	if o.Error != "" {
+		// catalogue error answer its current status, not the one kept
+		err := errorOf(o.Error)
+		if _, ok := err.(*errcode.Error); ok {
+			return o.Op, StatusOf(err), err
+		}
+		return o.Op, o.Status, err
+	}
+
+	return o.Op, o.Status, nil
+}
+
+func errorOf(msg string) error {
+	for _, err := range businessErrors {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,274 @@
+package wallet
+
+import (
+	"strconv"
+	"time"
+
+	guuid "github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// Hold ...
+func (s *pgStore) Hold(op *Operation) error {
+	w := &wallet{}
+	w.db = database.GetDBInstance()
+	w.post = op.post
+
+	err := w.db.Transaction(func(tx *gorm.DB) error {
+		if err := w.checkRequestID(tx); err != nil {
+			return err
+		}
+
+		wallet, err := w.lockWallet(tx, op.Agent, op.User, op.Currency)
+		if err != nil {
+			return err
+		}
+
+		if wallet.Amount < op.Amount {
+			return ErrNotEnoughBalance
+		}
+
+		now := time.Now()
+		id := guuid.New()
+
+		order := &modules.Order{
+			ID:           id,
+			UserID:       wallet.UserID,
+			WalletID:     wallet.ID,
+			GameID:       op.GameID,
+			OpType:       modules.WalletHold,
+			RequestID:    op.RequestID,
+			BeforeAmount: wallet.Amount,
+			AfterAmount:  wallet.Amount - op.Amount,
+			Status:       modules.OrderOk,
+			CreateAt:     now,
+			UpdateAt:     now,
+		}
+		hold := &modules.Hold{
+			ID:       id,
+			UserID:   wallet.UserID,
+			WalletID: wallet.ID,
+			OrderID:  id,
+			GameID:   op.GameID,
+			Amount:   op.Amount,
+			Status:   modules.HoldHeld,
+			ExpireAt: *op.ExpireAt,
+			CreateAt: now,
+			UpdateAt: now,
+		}
+
+		wallet.Amount = order.AfterAmount
+		wallet.Held = wallet.Held + hold.Amount
+		wallet.UpdateAt = now
+
+		if err := tx.Save(wallet).Error; err != nil {
+			return txError(err, "update user balance error")
+		}
+		if err := tx.Create(order).Error; err != nil {
+			return txError(err, "create hold error")
+		}
+		if err := tx.Create(hold).Error; err != nil {
+			return txError(err, "create hold error")
+		}
+		if err := ledger.Post(tx, &id, op.OpType, op.Lines()); err != nil {
+			return txError(err, "post ledger error")
+		}
+
+		op.OrderID = order.ID.String()
+		op.HoldID = order.ID.String()
+		op.BeforeAmount = order.BeforeAmount
+		op.AfterAmount = order.AfterAmount
+		op.UpdateAt = order.UpdateAt
+
+		return w.keepOutcome(tx, op)
+	})
+	if err != nil {
+		return pgError(err)
+	}
+	op.kept = true
+
+	return nil
+}
+
+// Settle ...
+func (s *pgStore) Settle(op *Operation) error {
+	w := &wallet{}
+	w.db = database.GetDBInstance()
+	w.post = op.post
+
+	err := w.db.Transaction(func(tx *gorm.DB) error {
+		if err := w.checkRequestID(tx); err != nil {
+			return err
+		}
+
+		wallet, err := w.findWallet(tx, op.Agent, op.User, op.Currency)
+		if err != nil {
+			return err
+		}
+
+		id, err := guuid.Parse(op.HoldID)
+		if err != nil {
+			return ErrHoldNotFound
+		}
+
+		hold := &modules.Hold{}
+		r := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND wallet_id = ?", id, wallet.ID).Limit(1).Find(hold)
+		if r.Error != nil {
+			return txError(r.Error, "get hold error")
+		}
+		if r.RowsAffected == 0 {
+			return ErrHoldNotFound
+		}
+
+		captured := money.Amount(0)
+		status := modules.HoldReleased
+
+		switch {
+		case hold.Status != modules.HoldHeld:
+			return ErrHoldSettled
+		case !time.Now().Before(hold.ExpireAt):
+			return ErrHoldExpired
+		case op.OpType == modules.WalletCapture:
+			captured = op.Amount
+			status = modules.HoldCaptured
+			if captured > hold.Amount {
+				return ErrHoldAmount
+			}
+		case op.Amount != hold.Amount:
+			return ErrHoldAmount
+		}
+
+		order, err := settleHold(tx, hold, status, captured, op.RequestID, op.OpType)
+		if err != nil {
+			return err
+		}
+
+		op.OrderID = order.ID.String()
+		op.RefOrderID = order.RefOrderID.String()
+		op.BeforeAmount = order.BeforeAmount
+		op.AfterAmount = order.AfterAmount
+		op.UpdateAt = order.CreateAt
+
+		return w.keepOutcome(tx, op)
+	})
+	if err != nil {
+		return pgError(err)
+	}
+	op.kept = true
+
+	return nil
+}
+
+// ExpireHolds ...
+func (s *pgStore) ExpireHolds(limit int) (int, error) {
+	db := database.GetDBInstance()
+
+	ids := []guuid.UUID{}
+	r := db.Conn().Model(&modules.Hold{}).
+		Where("status = ? AND expire_at <= ?", modules.HoldHeld, time.Now()).
+		Limit(limit).Pluck("id", &ids)
+	if r.Error != nil {
+		return 0, r.Error
+	}
+
+	n := 0
+	for _, id := range ids {
+		expired := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// recheck under lock, may be settled after select
+			hold := &modules.Hold{}
+			r := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND status = ?", id, modules.HoldHeld).Limit(1).Find(hold)
+			if r.Error != nil || r.RowsAffected == 0 {
+				return r.Error
+			}
+
+			_, err := settleHold(tx, hold, modules.HoldExpired, 0, "", modules.WalletRelease)
+			expired = err == nil
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+		if expired {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// settleHold give hold amount not captured back to wallet and record order,
+// hold must be locked by tx
+func settleHold(tx *gorm.DB, hold *modules.Hold, status modules.HoldStatus, captured money.Amount, requestID string, opType modules.WalletOps) (*modules.Order, error) {
+	wallet := &modules.Wallet{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(wallet, hold.WalletID).Error; err != nil {
+		return nil, txError(err, "lock user wallet error")
+	}
+
+	now := time.Now()
+	order := &modules.Order{
+		ID:           guuid.New(),
+		UserID:       wallet.UserID,
+		WalletID:     wallet.ID,
+		GameID:       hold.GameID,
+		OpType:       opType,
+		RequestID:    requestID,
+		RefOrderID:   &hold.OrderID,
+		BeforeAmount: wallet.Amount,
+		AfterAmount:  wallet.Amount + hold.Amount - captured,
+		Status:       modules.OrderOk,
+		Comment:      string(status),
+		CreateAt:     now,
+		UpdateAt:     now,
+	}
+
+	wallet.Amount = order.AfterAmount
+	wallet.Held = wallet.Held - hold.Amount
+	wallet.UpdateAt = now
+
+	hold.Status = status
+	hold.Captured = captured
+	hold.UpdateAt = now
+
+	if err := tx.Save(wallet).Error; err != nil {
+		return nil, txError(err, "update user balance error")
+	}
+	if err := tx.Save(hold).Error; err != nil {
+		return nil, txError(err, "update hold error")
+	}
+	if err := tx.Create(order).Error; err != nil {
+		return nil, txError(err, "create hold order error")
+	}
+
+	// hold owner for ledger account, expiry do not come with post data
+	user := &modules.User{}
+	if err := tx.First(user, wallet.UserID).Error; err != nil {
+		return nil, txError(err, "get user data error")
+	}
+
+	op := &Operation{
+		Agent:        strconv.Itoa(user.AgentID),
+		User:         strconv.Itoa(user.ID),
+		Currency:     wallet.Currency,
+		OpType:       opType,
+		Amount:       hold.Amount,
+		BeforeAmount: order.BeforeAmount,
+		AfterAmount:  order.AfterAmount,
+	}
+	if opType == modules.WalletCapture {
+		op.Amount = captured
+	}
+	if err := ledger.Post(tx, &order.ID, opType, op.Lines()); err != nil {
+		return nil, txError(err, "post ledger error")
+	}
+
+	return order, nil
+}
@@ -0,0 +1,690 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	guuid "github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+type pgStore struct{}
+
+type wallet struct {
+	db   *database.DBConn
+	data *modules.Wallet
+	post *modules.PostDatav2
+}
+
+// GetBalance ...
+func (s *pgStore) GetBalance(agent, user, currency string) (money.Amount, error) {
+	w := &wallet{db: database.GetDBInstance()}
+
+	data, err := w.findWallet(w.db.Conn(), agent, user, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return data.Amount, nil
+}
+
+// Balance ...
+func (s *pgStore) Balance(agent, user, currency string) (*Balance, error) {
+	w := &wallet{db: database.GetDBInstance()}
+
+	data, err := w.findWallet(w.db.Conn(), agent, user, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Balance{
+		Agent:      agent,
+		User:       user,
+		Currency:   currency,
+		Amount:     data.Amount,
+		Held:       data.Held,
+		LastChange: data.UpdateAt,
+	}
+
+	o := &modules.Order{}
+	r := w.db.Conn().Select("game_id").
+		Where("wallet_id = ? AND op_type = ?", data.ID, modules.WalletDeduct).
+		Order("create_at desc").Limit(1).Find(o)
+	if r.Error != nil {
+		return nil, errors.New("get order data error")
+	}
+	b.LastGameID = o.GameID
+
+	return b, nil
+}
+
+// orderRow order of search joined with user and wallet
+type orderRow struct {
+	modules.Order
+	AgentID  int
+	Currency string
+}
+
+// SearchOrders ...
+func (s *pgStore) SearchOrders(q *OrderQuery) ([]*OrderRecord, error) {
+	db, err := orderQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []*orderRow{}
+	if err := db.Order("orders.create_at desc, orders.id desc").Limit(q.Limit + 1).Scan(&rows).Error; err != nil {
+		return nil, errors.New("get order data error")
+	}
+
+	list := []*OrderRecord{}
+	for _, r := range rows {
+		list = append(list, r.record())
+	}
+
+	return list, nil
+}
+
+// ExportOrders read by row cursor, not whole result at once
+func (s *pgStore) ExportOrders(q *OrderQuery, fn func(*OrderRecord) error) error {
+	db, err := orderQuery(q)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Order("orders.create_at, orders.id").Rows()
+	if err != nil {
+		return errors.New("get order data error")
+	}
+	defer rows.Close()
+
+	conn := database.GetDBInstance().Conn()
+	for rows.Next() {
+		r := &orderRow{}
+		if err := conn.ScanRows(rows, r); err != nil {
+			return errors.New("get order data error")
+		}
+		if err := fn(r.record()); err != nil {
+			return err
+		}
+	}
+	if rows.Err() != nil {
+		return errors.New("get order data error")
+	}
+
+	return nil
+}
+
+// orderQuery orders of query filter and cursor, joined with user and wallet
+func orderQuery(q *OrderQuery) (*gorm.DB, error) {
+	aid, _ := strconv.Atoi(q.Agent)
+
+	db := database.GetDBInstance().Conn().Table("orders").
+		Select("orders.*, users.agent_id, wallets.currency").
+		Joins("join users on users.id = orders.user_id").
+		Joins("join wallets on wallets.id = orders.wallet_id").
+		Where("users.agent_id = ?", aid)
+
+	if q.User != "" {
+		uid, _ := strconv.Atoi(q.User)
+		db = db.Where("orders.user_id = ?", uid)
+	}
+	if q.Currency != "" {
+		db = db.Where("wallets.currency = ?", q.Currency)
+	}
+	if !q.From.IsZero() {
+		db = db.Where("orders.create_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		db = db.Where("orders.create_at < ?", q.To)
+	}
+	if q.OpType != "" {
+		db = db.Where("orders.op_type = ?", q.OpType)
+	}
+	if q.GameID != 0 {
+		db = db.Where("orders.game_id = ?", q.GameID)
+	}
+	if q.Status != "" {
+		db = db.Where("orders.status = ?", q.Status)
+	}
+	if q.RequestID != "" {
+		db = db.Where("orders.request_id = ?", q.RequestID)
+	}
+	if q.afterID != "" {
+		id, err := guuid.Parse(q.afterID)
+		if err != nil {
+			return nil, ErrCursor
+		}
+		db = db.Where("(orders.create_at, orders.id) < (?, ?)", q.afterTime, id)
+	}
+
+	return db, nil
+}
+
+// record order record of row
+func (r *orderRow) record() *OrderRecord {
+	o := &OrderRecord{
+		OrderID:      r.ID.String(),
+		Agent:        strconv.Itoa(r.AgentID),
+		User:         strconv.Itoa(r.UserID),
+		Currency:     r.Currency,
+		OpType:       r.OpType,
+		RequestID:    r.RequestID,
+		GameID:       r.GameID,
+		Status:       r.Status,
+		BeforeAmount: r.BeforeAmount,
+		AfterAmount:  r.AfterAmount,
+		FXCurrency:   r.FXCurrency,
+		FXAmount:     r.FXAmount,
+		CreateAt:     r.CreateAt,
+	}
+	if r.RefOrderID != nil {
+		o.RefOrderID = r.RefOrderID.String()
+	}
+	if r.Rate != nil {
+		o.Rate = *r.Rate
+	}
+
+	return o
+}
+
+// ApplyDelta ...
+func (s *pgStore) ApplyDelta(op *Operation) error {
+	w := &wallet{}
+	w.db = database.GetDBInstance()
+	w.post = op.post
+
+	err := w.db.Transaction(func(tx *gorm.DB) error {
+		if err := w.checkRequestID(tx); err != nil {
+			return err
+		}
+
+		wallet, err := w.lockWallet(tx, op.Agent, op.User, op.Currency)
+		if err != nil {
+			return err
+		}
+
+		if wallet.Amount+op.Delta() < 0 {
+			return ErrNotEnoughBalance
+		}
+
+		now := time.Now()
+		order := &modules.Order{
+			ID:           guuid.New(),
+			UserID:       wallet.UserID,
+			WalletID:     wallet.ID,
+			GameID:       op.GameID,
+			OpType:       op.OpType,
+			RequestID:    op.RequestID,
+			BeforeAmount: wallet.Amount,
+			AfterAmount:  wallet.Amount + op.Delta(),
+			Status:       modules.OrderOk,
+			CreateAt:     now,
+			UpdateAt:     now,
+		}
+		fxOrder(order, op.FXCurrency, op.FXAmount, op.Rate)
+
+		wallet.Amount = order.AfterAmount
+		wallet.UpdateAt = now
+
+		if err := tx.Save(wallet).Error; err != nil {
+			return txError(err, "update user balance error")
+		}
+		if err := tx.Create(order).Error; err != nil {
+			return txError(err, "create order error")
+		}
+
+		op.OrderID = order.ID.String()
+		op.BeforeAmount = order.BeforeAmount
+		op.AfterAmount = order.AfterAmount
+		op.UpdateAt = order.UpdateAt
+		if err := ledger.Post(tx, &order.ID, op.OpType, op.Lines()); err != nil {
+			return txError(err, "post ledger error")
+		}
+
+		return w.keepOutcome(tx, op)
+	})
+	if err != nil {
+		return pgError(err)
+	}
+	op.kept = true
+
+	return nil
+}
+
+// Rollback ...
+func (s *pgStore) Rollback(op *Operation) error {
+	w := &wallet{}
+	w.db = database.GetDBInstance()
+	w.post = op.post
+
+	err := w.db.Transaction(func(tx *gorm.DB) error {
+		if err := w.checkRequestID(tx); err != nil {
+			return err
+		}
+
+		wallet, err := w.lockWallet(tx, op.Agent, op.User, op.Currency)
+		if err != nil {
+			return err
+		}
+		w.data = wallet
+
+		ref, err := w.findRefOrder(tx, op)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case ref.Status == modules.OrderRollback:
+			return ErrAlreadyRollback
+		case ref.Status != modules.OrderOk || !Rollbackable(ref.OpType):
+			return ErrNotRollbackable
+		}
+
+		delta := ref.BeforeAmount - ref.AfterAmount
+		if delta.Abs() != op.Amount {
+			return ErrRollbackAmount
+		}
+
+		// only one rollback can move origin order out of ok
+		m := tx.Model(&modules.Order{}).
+			Where("id = ? AND status = ?", ref.ID, modules.OrderOk).
+			Updates(map[string]interface{}{"status": modules.OrderRollback, "update_at": time.Now()})
+		if m.Error != nil {
+			return txError(m.Error, "update origin order error")
+		}
+		if m.RowsAffected == 0 {
+			return ErrAlreadyRollback
+		}
+
+		if wallet.Amount+delta < 0 {
+			return ErrNotEnoughBalance
+		}
+
+		now := time.Now()
+		order := &modules.Order{
+			ID:           guuid.New(),
+			UserID:       wallet.UserID,
+			WalletID:     wallet.ID,
+			GameID:       ref.GameID,
+			OpType:       modules.WalletRollback,
+			RequestID:    op.RequestID,
+			RefOrderID:   &ref.ID,
+			BeforeAmount: wallet.Amount,
+			AfterAmount:  wallet.Amount + delta,
+			Status:       modules.OrderOk,
+			CreateAt:     now,
+			UpdateAt:     now,
+		}
+
+		wallet.Amount = order.AfterAmount
+		wallet.UpdateAt = now
+
+		if err := tx.Save(wallet).Error; err != nil {
+			return txError(err, "update user balance error")
+		}
+		if err := tx.Create(order).Error; err != nil {
+			return txError(err, "create rollback order error")
+		}
+
+		lines, err := refLines(tx, op, ref)
+		if err != nil {
+			return txError(err, "get ledger error")
+		}
+		if err := ledger.Post(tx, &order.ID, modules.WalletRollback, ledger.Reverse(lines)); err != nil {
+			return txError(err, "post ledger error")
+		}
+
+		op.OrderID = order.ID.String()
+		op.RefOrderID = order.RefOrderID.String()
+		op.BeforeAmount = order.BeforeAmount
+		op.AfterAmount = order.AfterAmount
+		op.UpdateAt = order.UpdateAt
+
+		return w.keepOutcome(tx, op)
+	})
+	if err != nil {
+		return pgError(err)
+	}
+	op.kept = true
+
+	return nil
+}
+
+// Transfer ...
+func (s *pgStore) Transfer(op *Operation) error {
+	w := &wallet{}
+	w.db = database.GetDBInstance()
+	w.post = op.post
+
+	toCurrency, toAmount := op.Credit()
+
+	err := w.db.Transaction(func(tx *gorm.DB) error {
+		if err := w.checkRequestID(tx); err != nil {
+			return err
+		}
+
+		from, err := w.findWallet(tx, op.Agent, op.User, op.Currency)
+		if err != nil {
+			return err
+		}
+		to, err := w.findWallet(tx, op.Agent, op.ToUser, toCurrency)
+		if err != nil {
+			return err
+		}
+
+		// lock in wallet id order, two opposite transfers can not deadlock
+		locks := []*modules.Wallet{from, to}
+		if from.ID > to.ID {
+			locks = []*modules.Wallet{to, from}
+		}
+		for _, l := range locks {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(l, l.ID).Error; err != nil {
+				return txError(err, "lock user wallet error")
+			}
+		}
+
+		if from.Amount < op.Amount {
+			return ErrNotEnoughBalance
+		}
+
+		now := time.Now()
+		outID, inID := guuid.New(), guuid.New()
+
+		out := modules.Order{
+			ID:           outID,
+			UserID:       from.UserID,
+			WalletID:     from.ID,
+			OpType:       modules.WalletTransferOut,
+			RequestID:    op.RequestID,
+			RefOrderID:   &inID,
+			BeforeAmount: from.Amount,
+			AfterAmount:  from.Amount - op.Amount,
+			Status:       modules.OrderOk,
+			CreateAt:     now,
+			UpdateAt:     now,
+		}
+		in := modules.Order{
+			ID:           inID,
+			UserID:       to.UserID,
+			WalletID:     to.ID,
+			OpType:       modules.WalletTransferIn,
+			RequestID:    op.RequestID,
+			RefOrderID:   &outID,
+			BeforeAmount: to.Amount,
+			AfterAmount:  to.Amount + toAmount,
+			Status:       modules.OrderOk,
+			CreateAt:     now,
+			UpdateAt:     now,
+		}
+		if op.Rate != 0 {
+			fxOrder(&out, toCurrency, toAmount, op.Rate)
+			fxOrder(&in, op.Currency, op.Amount, op.Rate)
+		}
+
+		from.Amount = out.AfterAmount
+		from.UpdateAt = now
+		to.Amount = in.AfterAmount
+		to.UpdateAt = now
+
+		if err := tx.Save(from).Error; err != nil {
+			return txError(err, "update user balance error")
+		}
+		if err := tx.Save(to).Error; err != nil {
+			return txError(err, "update user balance error")
+		}
+		if err := tx.Create(&[]modules.Order{out, in}).Error; err != nil {
+			return txError(err, "create transfer order error")
+		}
+
+		if err := ledger.Post(tx, &outID, op.OpType, op.Lines()); err != nil {
+			return txError(err, "post ledger error")
+		}
+
+		op.OrderID = out.ID.String()
+		op.RefOrderID = in.ID.String()
+		op.ToOrderID = in.ID.String()
+		op.BeforeAmount = out.BeforeAmount
+		op.AfterAmount = out.AfterAmount
+		op.ToBeforeAmount = in.BeforeAmount
+		op.ToAfterAmount = in.AfterAmount
+		op.UpdateAt = out.UpdateAt
+
+		return w.keepOutcome(tx, op)
+	})
+	if err != nil {
+		return pgError(err)
+	}
+	op.kept = true
+
+	return nil
+}
+
+// txError keep error of transaction lost to a concurrent one, so
+// database.Transaction run it again, other db error replaced by msg
+func txError(err error, msg string) error {
+	if database.Retryable(err) {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// pgError wallet error of finished transaction, lost after every retry
+// is busy
+func pgError(err error) error {
+	if database.Retryable(err) {
+		return ErrBusy
+	}
+	return err
+}
+
+// refLines ledger lines of order to rollback, orders older than the ledger
+// have no entry and get lines rebuilt from the order
+func refLines(tx *gorm.DB, op *Operation, ref *modules.Order) ([]modules.JournalLine, error) {
+	lines, err := ledger.EntryLines(tx, ref.ID)
+	if err != nil || len(lines) > 0 {
+		return lines, err
+	}
+
+	o := &Operation{
+		Agent:        op.Agent,
+		User:         op.User,
+		Currency:     op.Currency,
+		OpType:       ref.OpType,
+		Amount:       (ref.AfterAmount - ref.BeforeAmount).Abs(),
+		BeforeAmount: ref.BeforeAmount,
+		AfterAmount:  ref.AfterAmount,
+		FXCurrency:   ref.FXCurrency,
+		FXAmount:     ref.FXAmount,
+	}
+	if ref.Rate != nil {
+		o.Rate = *ref.Rate
+	}
+
+	return o.Lines(), nil
+}
+
+// fxOrder record conversion on order, nothing for unconverted
+func fxOrder(o *modules.Order, currency string, amount money.Amount, rate money.Rate) {
+	if rate == 0 {
+		return
+	}
+	o.FXCurrency = currency
+	o.FXAmount = amount
+	o.Rate = &rate
+}
+
+func (w *wallet) findRefOrder(tx *gorm.DB, op *Operation) (*modules.Order, error) {
+	ref := &modules.Order{}
+	q := tx.Where("wallet_id = ?", w.data.ID)
+
+	if op.RefOrderID != "" {
+		id, err := guuid.Parse(op.RefOrderID)
+		if err != nil {
+			return nil, ErrOrderNotFound
+		}
+		q = q.Where("id = ?", id)
+	} else {
+		q = q.Where("request_id = ? AND status IN ?", op.RefRequestID,
+			[]modules.OrderStatus{modules.OrderOk, modules.OrderRollback})
+	}
+
+	r := q.Limit(1).Find(&ref)
+	if r.Error != nil {
+		return nil, txError(r.Error, "get order error")
+	}
+	if r.RowsAffected <= 0 {
+		return nil, ErrOrderNotFound
+	}
+
+	return ref, nil
+}
+
+// findWallet user wallet of agent in currency read by db, tx or conn
+func (w *wallet) findWallet(db *gorm.DB, agent, user, currency string) (*modules.Wallet, error) {
+	uid, _ := strconv.Atoi(user)
+	aid, _ := strconv.Atoi(agent)
+	wallet := &modules.Wallet{}
+
+	r := db.
+		Table("users").Select("wallets.*").
+		Joins("join wallets on wallets.user_id = users.id and wallets.currency = ?", currency).
+		Find(&wallet, modules.User{ID: uid, AgentID: aid})
+
+	if r.Error != nil {
+		return nil, txError(r.Error, "get user data error")
+	}
+
+	if r.RowsAffected <= 0 {
+		// user may still hold wallet of other currency
+		var n int64
+		c := db.Model(&modules.Wallet{}).
+			Joins("join users on users.id = wallets.user_id").
+			Where("users.id = ? AND users.agent_id = ?", uid, aid).
+			Count(&n)
+		if c.Error != nil {
+			return nil, txError(c.Error, "get user data error")
+		}
+		if n > 0 {
+			return nil, ErrCurrencyMismatch
+		}
+		return nil, ErrUserNotFound
+	}
+
+	return wallet, nil
+}
+
+// lockWallet user wallet read again and locked by tx
+func (w *wallet) lockWallet(tx *gorm.DB, agent, user, currency string) (*modules.Wallet, error) {
+	wallet, err := w.findWallet(tx, agent, user, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(wallet, wallet.ID).Error; err != nil {
+		return nil, txError(err, "lock user wallet error")
+	}
+
+	return wallet, nil
+}
+
+// SaveOutcome ...
+func (s *pgStore) SaveOutcome(o *Outcome) error {
+	return saveOutcome(database.GetDBInstance().Conn(), o)
+}
+
+// keepRequestOutcome upsert is the save, only over claim not finished or
+// row over expire. outcome kept by other request of the id not replaced
+const keepRequestOutcome = `INSERT INTO api_request_ids (agent, id, ip, fingerprint, status, outcome, create_at, expire_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (agent, id) DO UPDATE SET ip = excluded.ip, fingerprint = excluded.fingerprint, status = excluded.status,
+	outcome = excluded.outcome, create_at = excluded.create_at, expire_at = excluded.expire_at
+WHERE api_request_ids.status = 0 OR api_request_ids.expire_at < excluded.create_at`
+
+// saveOutcome save o by db, tx of the wallet change or conn
+func saveOutcome(db *gorm.DB, o *Outcome) error {
+	u, err := guuid.Parse(o.RequestID)
+	if err != nil {
+		return ErrInvalidRequestID
+	}
+
+	j, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	ip := ""
+	if o.Op.post != nil {
+		ip = o.Op.post.ClientIP
+	}
+
+	now := time.Now()
+	return db.Exec(keepRequestOutcome, o.Agent, u, ip, o.Fingerprint, o.Status, string(j), now, now.Add(requestIDTTL(o.Agent))).Error
+}
+
+// FindOutcome ...
+func (s *pgStore) FindOutcome(agent, requestID string) (*Outcome, error) {
+	u, err := guuid.Parse(requestID)
+	if err != nil {
+		return nil, ErrInvalidRequestID
+	}
+
+	a := &modules.APIRequestIDs{}
+	r := database.GetDBInstance().Conn().Where("agent = ? AND id = ? AND status <> 0 AND expire_at >= ?", agent, u, time.Now()).Limit(1).Find(a)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	if r.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	o := &Outcome{}
+	if err := json.Unmarshal([]byte(a.Outcome), o); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// claimRequestID insert is the check, two same request at once can not both
+// pass. row over expire not yet cleaned is taken over as new. run in tx of
+// the wallet change, failed tx leave no claim behind
+const claimRequestID = `INSERT INTO api_request_ids (agent, id, ip, create_at, expire_at) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (agent, id) DO UPDATE SET ip = excluded.ip, fingerprint = '', status = 0, outcome = '',
+	create_at = excluded.create_at, expire_at = excluded.expire_at
+WHERE api_request_ids.expire_at < excluded.create_at`
+
+func (w *wallet) checkRequestID(tx *gorm.DB) error {
+	u, err := guuid.Parse(w.post.RequestID)
+	if err != nil {
+		return ErrInvalidRequestID
+	}
+
+	now := time.Now()
+	r := tx.Exec(claimRequestID, w.post.Agent, u, w.post.ClientIP, now, now.Add(requestIDTTL(w.post.Agent)))
+	if r.Error != nil {
+		return txError(r.Error, "check request id error")
+	}
+	if r.RowsAffected == 0 {
+		return ErrRequestIDRepeat
+	}
+
+	return nil
+}
+
+// keepOutcome ok outcome of op saved by tx of the wallet change, commit keep
+// both or none, no claim left without result
+func (w *wallet) keepOutcome(tx *gorm.DB, op *Operation) error {
+	if err := saveOutcome(tx, newOutcome(op, StatusOf(nil), nil)); err != nil {
+		return txError(err, "save outcome error")
+	}
+	return nil
+}
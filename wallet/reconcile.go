@@ -0,0 +1,535 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	kredis "github.com/kyos0109/test-wallet/redis"
+)
+
+// reconcile repair source of truth
+const (
+	RepairFromRedis    = "redis"
+	RepairFromPostgres = "postgres"
+)
+
+// reconcile error
+var (
+	ErrRepairSource  = errors.New("repair source must be redis or postgres")
+	ErrRepairMissing = errors.New("source of truth has no wallet")
+	ErrRepairChanged = errors.New("wallet changed while reconcile, run again")
+)
+
+// opLogPage op logs read per redis call by reconcile
+const opLogPage = 1000
+
+// Drift one wallet redis and postgres disagree on. Redis and Postgres are
+// nil when that side has no wallet. Pending is delta of op stream not
+// synced to postgres yet, it is taken out of every diff, so diff is
+// redis minus pending minus postgres
+type Drift struct {
+	Agent          string        `json:"agent"`
+	User           string        `json:"user"`
+	Currency       string        `json:"currency"`
+	Redis          *money.Amount `json:"redis"`
+	Postgres       *money.Amount `json:"postgres"`
+	RedisHeld      money.Amount  `json:"redisHeld"`
+	PostgresHeld   money.Amount  `json:"postgresHeld"`
+	Pending        money.Amount  `json:"pending"`
+	PendingHeld    money.Amount  `json:"pendingHeld"`
+	Diff           money.Amount  `json:"diff"`
+	HeldDiff       money.Amount  `json:"heldDiff"`
+	RedisOrders    money.Amount  `json:"redisOrders"`
+	PostgresOrders money.Amount  `json:"postgresOrders"`
+	OrderDiff      money.Amount  `json:"orderDiff"`
+	Repaired       bool          `json:"repaired"`
+	Error          string        `json:"error,omitempty"`
+}
+
+// ReconcileReport drift of every wallet found, orders sum delta of orders
+// created after Since. Dead count op logs sync gave up on
+type ReconcileReport struct {
+	StartAt    time.Time `json:"startAt"`
+	EndAt      time.Time `json:"endAt"`
+	Since      time.Time `json:"since"`
+	RepairFrom string    `json:"repairFrom,omitempty"`
+	Checked    int       `json:"checked"`
+	Pending    int       `json:"pending"`
+	Dead       int64     `json:"dead"`
+	Drifts     []*Drift  `json:"drifts"`
+}
+
+// Unresolved count of drifts not repaired
+func (r *ReconcileReport) Unresolved() int {
+	n := 0
+	for _, d := range r.Drifts {
+		if !d.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// pgBalance postgres side of one wallet
+type pgBalance struct {
+	AgentID  int
+	UserID   int
+	WalletID int
+	Currency string
+	Amount   money.Amount
+	Held     money.Amount
+}
+
+// pending op stream delta of one user key
+type pending struct {
+	amount money.Amount
+	held   money.Amount
+	orders money.Amount
+}
+
+// Reconcile compare every wallet of redis and postgres, balance, held and
+// order delta since reconcile window. repairFrom empty only report, else
+// move the other side to it, order history is never repaired.
+//
+// redis keep serving while reconcile, so every wallet drift in first pass
+// is checked again before reported
+func Reconcile(repairFrom string) (*ReconcileReport, error) {
+	if repairFrom != "" && repairFrom != RepairFromRedis && repairFrom != RepairFromPostgres {
+		return nil, ErrRepairSource
+	}
+
+	now := time.Now()
+	report := &ReconcileReport{
+		StartAt:    now,
+		Since:      now.Add(-walletConfig.ReconcileWindow),
+		RepairFrom: repairFrom,
+		Drifts:     []*Drift{},
+	}
+
+	keys, err := reconcileKeys()
+	if err != nil {
+		return nil, err
+	}
+	report.Checked = len(keys)
+
+	drifts, n, err := reconcilePass(keys, report.Since)
+	if err != nil {
+		return nil, err
+	}
+	if len(drifts) > 0 {
+		again := make([]string, 0, len(drifts))
+		for _, d := range drifts {
+			again = append(again, redisUserKey(d.Agent, d.User, d.Currency))
+		}
+		if drifts, n, err = reconcilePass(again, report.Since); err != nil {
+			return nil, err
+		}
+	}
+	report.Pending = n
+
+	// op logs sync gave up on, their wallet drift till handled by hand
+	if report.Dead, err = kredis.GetRedisClientInstance().OpStreamDeadLen(); err != nil {
+		return nil, err
+	}
+
+	for _, d := range drifts {
+		if repairFrom != "" {
+			if err := repairDrift(d, repairFrom); err != nil {
+				d.Error = err.Error()
+			} else {
+				d.Repaired = true
+			}
+		}
+		report.Drifts = append(report.Drifts, d)
+	}
+
+	report.EndAt = time.Now()
+	return report, nil
+}
+
+// reconcileKeys redis user key of every wallet on either side, sorted
+func reconcileKeys() ([]string, error) {
+	keys, err := kredis.GetRedisClientInstance().UserKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := pgBalances(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(keys)+len(list))
+	for _, k := range keys {
+		seen[k] = true
+	}
+	for _, b := range list {
+		seen[redisUserKey(strconv.Itoa(b.AgentID), strconv.Itoa(b.UserID), b.Currency)] = true
+	}
+
+	all := make([]string, 0, len(seen))
+	for k := range seen {
+		all = append(all, k)
+	}
+	sort.Strings(all)
+	return all, nil
+}
+
+// reconcilePass drift of keys and count of pending op logs
+func reconcilePass(keys []string, since time.Time) ([]*Drift, int, error) {
+	r := kredis.GetRedisClientInstance()
+
+	// op synced between pending and postgres read count twice, the
+	// second pass clear it
+	pend, n, err := pendingOps(since)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := []int{}
+	for _, k := range keys {
+		if _, u, _, ok := splitUserKey(k); ok {
+			if id, err := strconv.Atoi(u); err == nil {
+				users = append(users, id)
+			}
+		}
+	}
+
+	list, err := pgBalances(users)
+	if err != nil {
+		return nil, 0, err
+	}
+	orders, err := pgOrderDeltas(users, since)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pg := make(map[string]pgBalance, len(list))
+	for _, b := range list {
+		pg[redisUserKey(strconv.Itoa(b.AgentID), strconv.Itoa(b.UserID), b.Currency)] = b
+	}
+
+	drifts := []*Drift{}
+	for _, k := range keys {
+		agent, user, currency, ok := splitUserKey(k)
+		if !ok {
+			continue
+		}
+
+		d := &Drift{Agent: agent, User: user, Currency: currency}
+
+		amount, held, ok, err := r.UserWalletBalance(k)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			d.Redis = &amount
+			d.RedisHeld = held
+			if d.RedisOrders, err = redisOrderDelta(agent, user, currency, since); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if b, ok := pg[k]; ok {
+			d.Postgres = &b.Amount
+			d.PostgresHeld = b.Held
+			d.PostgresOrders = orders[b.WalletID]
+		}
+
+		p := &pending{}
+		if v, ok := pend[k]; ok {
+			p = v
+		}
+		d.Pending = p.amount
+		d.PendingHeld = p.held
+
+		redisAmount, pgAmount := money.Amount(0), money.Amount(0)
+		if d.Redis != nil {
+			redisAmount = *d.Redis
+		}
+		if d.Postgres != nil {
+			pgAmount = *d.Postgres
+		}
+		d.Diff = redisAmount - p.amount - pgAmount
+		d.HeldDiff = d.RedisHeld - p.held - d.PostgresHeld
+		d.OrderDiff = d.RedisOrders - p.orders - d.PostgresOrders
+
+		if d.Diff != 0 || d.HeldDiff != 0 || d.OrderDiff != 0 || (d.Redis == nil) != (d.Postgres == nil) {
+			drifts = append(drifts, d)
+		}
+	}
+
+	return drifts, n, nil
+}
+
+// pendingOps delta of op logs after sync checkpoint by user key
+func pendingOps(since time.Time) (map[string]*pending, int, error) {
+	cp := &modules.SyncCheckpoint{}
+	if err := database.GetDBInstance().Conn().Limit(1).Find(cp, "name = ?", opStreamCheckpoint).Error; err != nil {
+		return nil, 0, err
+	}
+
+	r := kredis.GetRedisClientInstance()
+	pend := make(map[string]*pending)
+	n := 0
+	last := cp.LastID
+	for {
+		entries, err := r.OpStreamRange(last, opLogPage)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, e := range entries {
+			last = e.ID
+			l := &opLog{}
+			if err := json.Unmarshal([]byte(e.Log), l); err != nil {
+				return nil, 0, errors.New("op " + e.ID + ": " + err.Error())
+			}
+
+			p, ok := pend[l.UserKey]
+			if !ok {
+				p = &pending{}
+				pend[l.UserKey] = p
+			}
+
+			delta := l.OpAmtAfter - l.OpAmtBefor
+			p.amount += delta
+			switch l.OpType {
+			case modules.WalletHold:
+				p.held -= delta
+			case modules.WalletCapture, modules.WalletRelease:
+				p.held -= l.Held
+			}
+			if opLogAfter(l, since) {
+				p.orders += delta
+			}
+			n++
+		}
+
+		if len(entries) < opLogPage {
+			return pend, n, nil
+		}
+	}
+}
+
+// redisOrderDelta sum delta of user op logs after since, ops list is newest
+// first so stop at first older one
+func redisOrderDelta(agent, user, currency string, since time.Time) (money.Amount, error) {
+	r := kredis.GetRedisClientInstance()
+	key := BuildRedisDataWithDelimiter(kredis.RediswallteOpsPerfix, agent, user, currency)
+
+	sum := money.Amount(0)
+	for start := int64(0); ; start += opLogPage {
+		logs, err := r.UserOpLogs(key, start, opLogPage)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, s := range logs {
+			l := &opLog{}
+			if err := json.Unmarshal([]byte(s), l); err != nil {
+				return 0, err
+			}
+			if !opLogAfter(l, since) {
+				return sum, nil
+			}
+			sum += l.OpAmtAfter - l.OpAmtBefor
+		}
+
+		if len(logs) < opLogPage {
+			return sum, nil
+		}
+	}
+}
+
+func opLogAfter(l *opLog, since time.Time) bool {
+	t, err := time.Parse(time.RFC3339Nano, l.HashMap.LastChange)
+	return err == nil && !t.Before(since)
+}
+
+// pgBalances postgres wallets with agent, nil users for every wallet
+func pgBalances(users []int) ([]pgBalance, error) {
+	q := database.GetDBInstance().Conn().Table("wallets").
+		Select("users.agent_id, wallets.user_id, wallets.id AS wallet_id, wallets.currency, wallets.amount, wallets.held").
+		Joins("INNER JOIN users ON users.id = wallets.user_id")
+	if users != nil {
+		q = q.Where("wallets.user_id IN ?", users)
+	}
+
+	list := []pgBalance{}
+	return list, q.Scan(&list).Error
+}
+
+// pgOrderDeltas sum delta of orders after since by wallet id
+func pgOrderDeltas(users []int, since time.Time) (map[int]money.Amount, error) {
+	rows := []struct {
+		WalletID int
+		Delta    money.Amount
+	}{}
+
+	q := database.GetDBInstance().Conn().Model(&modules.Order{}).
+		Select("wallet_id, SUM(after_amount - before_amount) AS delta").
+		Where("create_at >= ?", since).Group("wallet_id")
+	if users != nil {
+		q = q.Where("user_id IN ?", users)
+	}
+	if err := q.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	m := make(map[int]money.Amount, len(rows))
+	for _, r := range rows {
+		m[r.WalletID] = r.Delta
+	}
+	return m, nil
+}
+
+// repairDrift move balance and held of the other side to source holding
+// repair lock of the wallet, two reconcile run can not fix same wallet at
+// once. pending op logs kept out, sync worker still apply them. postgres
+// repaired post a repair entry against equity, see repairWallet. player op
+// take no lock, a wallet changed since read fail with ErrRepairChanged, see
+// kredis TryLock. both sides fenced by lease token, redis in repair script and
+// postgres in repair tx, so holder whose lease expired can not write over
+// a newer one
+func repairDrift(d *Drift, from string) error {
+	key := redisUserKey(d.Agent, d.User, d.Currency)
+
+	r := kredis.GetRedisClientInstance()
+	lease, err := r.TryLock(kredis.NewLock(key, "reconcile"))
+	if err != nil {
+		return err
+	}
+	defer r.UnLock(lease)
+
+	if from == RepairFromPostgres {
+		if d.Postgres == nil {
+			return ErrRepairMissing
+		}
+
+		ok, err := r.UserWalletRepair(key, lease.Token, d.Redis, d.RedisHeld, *d.Postgres+d.Pending, d.PostgresHeld+d.PendingHeld)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrRepairChanged
+		}
+		return nil
+	}
+
+	if d.Redis == nil {
+		return ErrRepairMissing
+	}
+
+	tx := database.GetDBInstance().Conn().Begin()
+
+	// first in tx, newer holder wait on the fence row till this tx end
+	if err := fenceRepair(tx, key, lease.Token); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// hold checkpoint so sync worker do not move postgres under repair
+	if _, err := lockCheckpoint(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	w, err := lockWallet(tx, d.Agent, d.User, d.Currency)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	pgAmount := money.Amount(0)
+	if d.Postgres != nil {
+		pgAmount = *d.Postgres
+	}
+	if w.Amount != pgAmount || w.Held != d.PostgresHeld {
+		tx.Rollback()
+		return ErrRepairChanged
+	}
+
+	if err := repairWallet(tx, d.Agent, d.User, w, *d.Redis-d.Pending, d.RedisHeld-d.PendingHeld); err != nil {
+		tx.Rollback()
+		return err
+	}
+	w.UpdateAt = time.Now()
+	if err := tx.Save(w).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !lease.Held() {
+		tx.Rollback()
+		return kredis.ErrLockLost
+	}
+
+	return tx.Commit().Error
+}
+
+// repairFence same check as redis luaFence, fence row only move forward.
+// no row when token older than last fenced repair of resource
+const repairFence = `INSERT INTO repair_fences (resource, token, update_at) VALUES (?, ?, ?)
+ON CONFLICT (resource) DO UPDATE SET token = excluded.token, update_at = excluded.update_at
+WHERE repair_fences.token <= excluded.token`
+
+// fenceRepair check token of repair in tx, ErrStaleToken when a newer lock
+// holder already repaired resource
+func fenceRepair(tx *gorm.DB, resource string, token int64) error {
+	r := tx.Exec(repairFence, resource, token, time.Now())
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		return kredis.ErrStaleToken
+	}
+	return nil
+}
+
+// repairWallet move wallet to amount and held with a repair entry against
+// equity, so ledger keep matching wallet and repair not look like opening.
+// caller save wallet
+func repairWallet(tx *gorm.DB, agent, user string, w *modules.Wallet, amount, held money.Amount) error {
+	if amount == w.Amount && held == w.Held {
+		return nil
+	}
+
+	log.Println("repair wallet", agent, user, w.Currency, amount-w.Amount, held-w.Held)
+	equity := ledger.Equity(w.Currency)
+	lines := append(
+		ledger.Move(equity, ledger.Wallet(agent, user, w.Currency), w.Currency, amount-w.Amount),
+		ledger.Move(equity, ledger.Held(agent, user, w.Currency), w.Currency, held-w.Held)...,
+	)
+	if err := ledger.Post(tx, nil, modules.WalletRepair, lines); err != nil {
+		return err
+	}
+
+	w.Amount = amount
+	w.Held = held
+	return nil
+}
+
+func redisUserKey(agent, user, currency string) string {
+	return BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, agent, user, currency)
+}
+
+// splitUserKey agent, user and currency of redis user key
+func splitUserKey(key string) (string, string, string, bool) {
+	parts := strings.Split(key, kredis.RedisDelimiter)
+	if len(parts) != 4 || parts[0] != kredis.RedisUserPerfix {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
@@ -0,0 +1,226 @@
+package wallet
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	kredis "github.com/kyos0109/test-wallet/redis"
+)
+
+func TestSplitUserKey(t *testing.T) {
+	tests := []struct {
+		key                   string
+		agent, user, currency string
+		ok                    bool
+	}{
+		{"Users:100:1:TWD", "100", "1", "TWD", true},
+		{"Users:100:1", "", "", "", false},
+		{"Orders:100:1:TWD", "", "", "", false},
+		{"Users:100:1:TWD:x", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		agent, user, currency, ok := splitUserKey(tt.key)
+		if agent != tt.agent || user != tt.user || currency != tt.currency || ok != tt.ok {
+			t.Errorf("split %q got %q %q %q %v", tt.key, agent, user, currency, ok)
+		}
+		if ok && redisUserKey(agent, user, currency) != tt.key {
+			t.Errorf("user key of %q is %q", tt.key, redisUserKey(agent, user, currency))
+		}
+	}
+}
+
+func TestStreamIDAfter(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1-0", "", true},
+		{"2-0", "1-5", true},
+		{"1-5", "2-0", false},
+		{"1-10", "1-9", true},
+		{"1-9", "1-10", false},
+		{"1-1", "1-1", false},
+		{"10-0", "9-0", true},
+	}
+
+	for _, tt := range tests {
+		if got := streamIDAfter(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s after %s is %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestOpLogAfter(t *testing.T) {
+	since := time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		lastChange string
+		want       bool
+	}{
+		{since.Format(time.RFC3339Nano), true},
+		{since.Add(time.Nanosecond).Format(time.RFC3339Nano), true},
+		{since.Add(-time.Second).Format(time.RFC3339Nano), false},
+		{"", false},
+		{"not a time", false},
+	}
+
+	for _, tt := range tests {
+		l := &opLog{}
+		l.HashMap.LastChange = tt.lastChange
+		if got := opLogAfter(l, since); got != tt.want {
+			t.Errorf("op log of %q after is %v, want %v", tt.lastChange, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileUnresolved(t *testing.T) {
+	r := &ReconcileReport{Drifts: []*Drift{{Repaired: true}, {}, {Error: ErrRepairChanged.Error()}}}
+	if n := r.Unresolved(); n != 2 {
+		t.Errorf("unresolved %d, want 2", n)
+	}
+}
+
+func amountOf(i int64) *money.Amount {
+	a := money.FromInt(i)
+	return &a
+}
+
+// fenceNewer repair fence taken by a newer lease of key, as when lease of
+// running repair expired and other reconcile got the lock
+const fenceNewer = 1 << 40
+
+func TestRepairRedis(t *testing.T) {
+	key := redisUserKey(testAgent, "1", "TWD")
+
+	tests := []struct {
+		name    string
+		redis   *money.Amount
+		fence   bool
+		wantErr error
+		want    money.Amount
+	}{
+		{"repaired", amountOf(100), false, nil, money.FromInt(60)},
+		{"changed since read", amountOf(90), false, ErrRepairChanged, money.FromInt(100)},
+		{"lease lost to newer", amountOf(100), true, kredis.ErrStaleToken, money.FromInt(100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRedisBalance(testAgent, "1", "TWD", money.FromInt(100))
+			if tt.fence {
+				testRedis.Set(kredis.FenceKey(key), strconv.Itoa(fenceNewer))
+			}
+
+			d := &Drift{Agent: testAgent, User: "1", Currency: "TWD", Redis: tt.redis, Postgres: amountOf(60)}
+			if err := repairDrift(d, RepairFromPostgres); err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+
+			b, err := GetStore(RedisBackend).GetBalance(testAgent, "1", "TWD")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b != tt.want {
+				t.Errorf("redis wallet %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileRepair(t *testing.T) {
+	tests := []struct {
+		name        string
+		from        string
+		wantRedis   money.Amount
+		wantPg      money.Amount
+		wantRepairs int64
+	}{
+		{"postgres from redis", RepairFromRedis, money.FromInt(100), money.FromInt(100), 1},
+		{"redis from postgres", RepairFromPostgres, money.FromInt(60), money.FromInt(60), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testPostgres(t)
+			setRedisBalance(testAgent, "1", "TWD", money.FromInt(100))
+			setPgBalance(t, db, "1", "TWD", money.FromInt(60))
+
+			report, err := Reconcile(tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Drifts) != 1 || report.Unresolved() != 0 {
+				t.Fatalf("drifts %d unresolved %d, want 1 repaired", len(report.Drifts), report.Unresolved())
+			}
+			if d := report.Drifts[0]; d.Diff != money.FromInt(40) {
+				t.Errorf("diff %s, want 40", d.Diff)
+			}
+
+			b, err := GetStore(RedisBackend).GetBalance(testAgent, "1", "TWD")
+			if err != nil || b != tt.wantRedis {
+				t.Errorf("redis wallet %s, want %s: %v", b, tt.wantRedis, err)
+			}
+			checkPgWallet(t, db, "1", "TWD", tt.wantPg, 0)
+
+			// repair of postgres is a repair entry against equity
+			var repairs int64
+			if err := db.Conn().Table("journal_entries").Where("op_type = ?", modules.WalletRepair).Count(&repairs).Error; err != nil {
+				t.Fatal(err)
+			}
+			if repairs != tt.wantRepairs {
+				t.Errorf("repair entries %d, want %d", repairs, tt.wantRepairs)
+			}
+
+			report, err = Reconcile("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Drifts) != 0 {
+				t.Errorf("drifts after repair %d, want 0", len(report.Drifts))
+			}
+		})
+	}
+}
+
+func TestRepairPostgres(t *testing.T) {
+	tests := []struct {
+		name     string
+		postgres *money.Amount
+		fence    bool
+		wantErr  error
+		want     money.Amount
+	}{
+		{"repaired", amountOf(60), false, nil, money.FromInt(100)},
+		{"changed since read", amountOf(50), false, ErrRepairChanged, money.FromInt(60)},
+		{"lease lost to newer", amountOf(60), true, kredis.ErrStaleToken, money.FromInt(60)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testPostgres(t)
+			setRedisBalance(testAgent, "1", "TWD", money.FromInt(100))
+			setPgBalance(t, db, "1", "TWD", money.FromInt(60))
+			if tt.fence {
+				err := db.Conn().Exec("INSERT INTO repair_fences (resource, token) VALUES (?, ?)", redisUserKey(testAgent, "1", "TWD"), fenceNewer).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := &Drift{Agent: testAgent, User: "1", Currency: "TWD", Redis: amountOf(100), Postgres: tt.postgres}
+			if err := repairDrift(d, RepairFromRedis); err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			checkPgWallet(t, db, "1", "TWD", tt.want, 0)
+
+			if l, err := ledger.Balance(db.Conn(), ledger.Equity("TWD")); err != nil || l != -tt.want {
+				t.Errorf("equity %s, want %s: %v", l, -tt.want, err)
+			}
+		})
+	}
+}
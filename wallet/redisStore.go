@@ -0,0 +1,624 @@
+package wallet
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	guuid "github.com/google/uuid"
+
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	kredis "github.com/kyos0109/test-wallet/redis"
+)
+
+type redisStore struct{}
+
+// GetBalance ...
+func (s *redisStore) GetBalance(agent, user, currency string) (money.Amount, error) {
+	rd := &modules.RedisData{
+		UserKey: BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, &agent, &user, &currency),
+	}
+
+	b := kredis.GetRedisClientInstance().UserWalletHGet(rd)
+	if b < 0 {
+		return 0, ErrUserNotFound
+	}
+
+	return b, nil
+}
+
+// Balance ...
+func (s *redisStore) Balance(agent, user, currency string) (*Balance, error) {
+	h, err := kredis.GetRedisClientInstance().UserWalletHGetAll(BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, agent, user, currency))
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := h[kredis.RedisHashWalletKey]
+	if !ok {
+		return nil, redisMissing(&Operation{Agent: agent, User: user, Currency: currency})
+	}
+
+	b := &Balance{Agent: agent, User: user, Currency: currency}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	b.Amount = money.Amount(i)
+
+	if v, ok := h[kredis.RedisHashHeldKey]; ok {
+		if i, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, err
+		}
+		b.Held = money.Amount(i)
+	}
+	// written by older build in other format, left empty
+	b.LastChange, _ = time.Parse(time.RFC3339Nano, h[kredis.RedisHashlastChangeKey])
+	b.LastGameID, _ = strconv.Atoi(h[kredis.RedisHashlastGameKey])
+
+	return b, nil
+}
+
+// ApplyDelta ...
+func (s *redisStore) ApplyDelta(op *Operation) error {
+	rd := newRedisData(op)
+
+	if op.OpType == modules.WalletDeduct {
+		rd.HashMap[kredis.RedisHashlastGameKey] = op.GameID
+	}
+	if err := claimWith(op, rd); err != nil {
+		return err
+	}
+
+	status, err := kredis.GetRedisClientInstance().UserWalletOp(rd, op.Delta())
+	if err != nil {
+		return err
+	}
+
+	return redisFinish(op, status, rd)
+}
+
+// Rollback ...
+func (s *redisStore) Rollback(op *Operation) error {
+	rd := newRedisData(op)
+	rd.RefOrderID = op.RefOrderID
+	if err := claimWith(op, rd); err != nil {
+		return err
+	}
+
+	status, err := kredis.GetRedisClientInstance().UserWalletRollback(rd, op.RefRequestID, op.Amount)
+	if err != nil {
+		return err
+	}
+
+	return redisFinish(op, status, rd)
+}
+
+// Transfer ...
+func (s *redisStore) Transfer(op *Operation) error {
+	from := newRedisData(op)
+
+	in := *op
+	in.User = op.ToUser
+	in.OpType = modules.WalletTransferIn
+	in.Currency, in.Amount = op.Credit()
+	if op.Rate != 0 {
+		in.FXCurrency, in.FXAmount = op.Currency, op.Amount
+	}
+	to := newRedisData(&in)
+	to.HashMap = from.HashMap
+
+	from.RefOrderID = to.OrderID
+	to.RefOrderID = from.OrderID
+	if err := claimWith(op, from, to); err != nil {
+		return err
+	}
+
+	status, err := kredis.GetRedisClientInstance().UserWalletTransfer(from, to, op.Amount, in.Amount)
+	if err != nil {
+		return err
+	}
+
+	return redisFinish(op, status, from, to)
+}
+
+// Hold ...
+func (s *redisStore) Hold(op *Operation) error {
+	rd := newRedisData(op)
+	rd.HoldID = rd.OrderID
+	rd.ExpireAt = op.ExpireAt
+	rd.HashMap[kredis.RedisHashlastGameKey] = op.GameID
+	if err := claimWith(op, rd); err != nil {
+		return err
+	}
+
+	status, err := kredis.GetRedisClientInstance().UserWalletHold(rd, op.Amount, *op.ExpireAt)
+	if err != nil {
+		return err
+	}
+
+	return redisFinish(op, status, rd)
+}
+
+// Settle ...
+func (s *redisStore) Settle(op *Operation) error {
+	rd := newRedisData(op)
+	rd.HoldID = op.HoldID
+	rd.RefOrderID = op.HoldID
+
+	mode := kredis.HoldCapture
+	if op.OpType == modules.WalletRelease {
+		mode = kredis.HoldRelease
+	}
+	if err := claimWith(op, rd); err != nil {
+		return err
+	}
+
+	status, err := kredis.GetRedisClientInstance().UserWalletSettle(rd, mode, op.Amount)
+	if err != nil {
+		return err
+	}
+
+	return redisFinish(op, status, rd)
+}
+
+// ExpireHolds ...
+func (s *redisStore) ExpireHolds(limit int) (int, error) {
+	r := kredis.GetRedisClientInstance()
+
+	ids, err := r.ExpiredHolds(int64(limit))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range ids {
+		user, ops, index, err := r.HoldOwner(id)
+		if err != nil {
+			return n, err
+		}
+
+		// missing hold still go to script, it clean the expiry entry
+		rd := &modules.RedisData{
+			HashMap:     map[string]interface{}{kredis.RedisHashlastChangeKey: time.Now()},
+			OrderID:     guuid.New().String(),
+			RequestID:   kredis.HoldExpire + kredis.RedisDelimiter + id,
+			OpType:      modules.WalletRelease,
+			HoldID:      id,
+			RefOrderID:  id,
+			UserKey:     user,
+			WallteOpKey: ops,
+			OrderIdxKey: index,
+		}
+		rd.OrderKey = BuildRedisDataWithDelimiter(kredis.RedisOrderPerfix, &rd.OrderID)
+
+		status, err := r.UserWalletSettle(rd, kredis.HoldExpire, 0)
+		if err != nil {
+			return n, err
+		}
+		if status == kredis.WalletOpOk {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// opLogSkew lastChange of op log is taken before script push it, so ops
+// list newest first may be out of time order by this much
+const opLogSkew = time.Second
+
+// SearchOrders ...
+func (s *redisStore) SearchOrders(q *OrderQuery) ([]*OrderRecord, error) {
+	r := kredis.GetRedisClientInstance()
+
+	user, currency := q.User, q.Currency
+	if user == "" {
+		user = "*"
+	}
+	if currency == "" {
+		currency = "*"
+	}
+	keys, err := r.ScanKeys(BuildRedisDataWithDelimiter(kredis.RediswallteOpsPerfix, q.Agent, user, currency))
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*OrderRecord{}
+	for _, key := range keys {
+		found, err := searchOpList(r, key, q)
+		if err != nil {
+			return nil, err
+		}
+		list = appendOrders(list, found, q.Limit+1)
+	}
+
+	return list, nil
+}
+
+// searchOpList matching orders of one wallet ops list, read newest first
+// and stop once older than From, or than a full page found, by skew
+func searchOpList(r *kredis.RedisClient, key string, q *OrderQuery) ([]*OrderRecord, error) {
+	found := []*OrderRecord{}
+
+	for start := int64(0); ; start += opLogPage {
+		logs, err := r.UserOpLogs(key, start, opLogPage)
+		if err != nil {
+			return nil, err
+		}
+
+		bound := q.From
+		if len(found) > q.Limit && found[q.Limit].CreateAt.After(bound) {
+			bound = found[q.Limit].CreateAt
+		}
+
+		stop := false
+		page := []*OrderRecord{}
+		for _, raw := range logs {
+			o, err := opLogRecord(raw)
+			if err != nil {
+				return nil, err
+			}
+			if !bound.IsZero() && o.CreateAt.Before(bound.Add(-opLogSkew)) {
+				stop = true
+				break
+			}
+			if q.match(o) && q.after(o) {
+				page = append(page, o)
+			}
+		}
+
+		matched, err := matchStatus(r, q, page)
+		if err != nil {
+			return nil, err
+		}
+		found = appendOrders(found, matched, q.Limit+1)
+
+		if stop || len(logs) < opLogPage {
+			return found, nil
+		}
+	}
+}
+
+// ExportOrders one wallet ops list after other, each read from its oldest
+// end, which new ops do not move
+func (s *redisStore) ExportOrders(q *OrderQuery, fn func(*OrderRecord) error) error {
+	r := kredis.GetRedisClientInstance()
+
+	user, currency := q.User, q.Currency
+	if user == "" {
+		user = "*"
+	}
+	if currency == "" {
+		currency = "*"
+	}
+	keys, err := r.ScanKeys(BuildRedisDataWithDelimiter(kredis.RediswallteOpsPerfix, q.Agent, user, currency))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := exportOpList(r, key, q, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportOpList matching orders of one wallet ops list oldest first, stop
+// once newer than To by skew
+func exportOpList(r *kredis.RedisClient, key string, q *OrderQuery, fn func(*OrderRecord) error) error {
+	for end := int64(0); ; end -= opLogPage {
+		logs, err := r.UserOpLogs(key, end-opLogPage, opLogPage)
+		if err != nil {
+			return err
+		}
+
+		stop := false
+		page := []*OrderRecord{}
+		for i := len(logs) - 1; i >= 0; i-- {
+			o, err := opLogRecord(logs[i])
+			if err != nil {
+				return err
+			}
+			if !q.To.IsZero() && o.CreateAt.After(q.To.Add(opLogSkew)) {
+				stop = true
+				break
+			}
+			if q.match(o) {
+				page = append(page, o)
+			}
+		}
+
+		matched, err := matchStatus(r, q, page)
+		if err != nil {
+			return err
+		}
+		for _, o := range matched {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+
+		if stop || len(logs) < opLogPage {
+			return nil
+		}
+	}
+}
+
+// matchStatus set status of orders and keep those match query, rollback
+// mark only the order key
+func matchStatus(r *kredis.RedisClient, q *OrderQuery, list []*OrderRecord) ([]*OrderRecord, error) {
+	ids := make([]string, len(list))
+	for i, o := range list {
+		ids[i] = o.OrderID
+	}
+	orders, err := r.OrderLogs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []*OrderRecord{}
+	for i, o := range list {
+		ref := &struct{ RollbackOrderID string }{}
+		if json.Unmarshal([]byte(orders[i]), ref) == nil && ref.RollbackOrderID != "" {
+			o.Status = modules.OrderRollback
+		}
+		if q.matchStatus(o) {
+			matched = append(matched, o)
+		}
+	}
+
+	return matched, nil
+}
+
+// opLogRecord order record of redis op log
+func opLogRecord(raw string) (*OrderRecord, error) {
+	l := &opLog{}
+	if err := json.Unmarshal([]byte(raw), l); err != nil {
+		return nil, err
+	}
+
+	d := &opLogDetail{}
+	if l.PostData != nil {
+		json.Unmarshal(l.PostData.Detail, d)
+	}
+
+	o := &OrderRecord{
+		OrderID:      l.OrderID,
+		OpType:       l.OpType,
+		RequestID:    l.RequestID,
+		GameID:       d.GameID,
+		RefOrderID:   l.RefOrderID,
+		Status:       modules.OrderOk,
+		BeforeAmount: l.OpAmtBefor,
+		AfterAmount:  l.OpAmtAfter,
+		FXCurrency:   l.FXCurrency,
+		FXAmount:     l.FXAmount,
+		Rate:         l.Rate,
+	}
+	o.Agent, o.User, o.Currency, _ = splitUserKey(l.UserKey)
+	o.CreateAt, _ = time.Parse(time.RFC3339Nano, l.HashMap.LastChange)
+
+	return o, nil
+}
+
+func requestIDKey(agent, requestID string) string {
+	return kredis.RedisRequestIDPerfix + kredis.RedisDelimiter + agent + kredis.RedisDelimiter + requestID
+}
+
+func outcomeKey(agent, requestID string) string {
+	return kredis.RedisOutcomePerfix + kredis.RedisDelimiter + agent + kredis.RedisDelimiter + requestID
+}
+
+// SaveOutcome ...
+func (s *redisStore) SaveOutcome(o *Outcome) error {
+	return kredis.GetRedisClientInstance().SetJSON(outcomeKey(o.Agent, o.RequestID), o, requestIDTTL(o.Agent))
+}
+
+// FindOutcome ...
+func (s *redisStore) FindOutcome(agent, requestID string) (*Outcome, error) {
+	r := kredis.GetRedisClientInstance()
+	o := &Outcome{}
+
+	ok, err := r.GetJSON(outcomeKey(agent, requestID), o)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return o, nil
+	}
+
+	// outcome not saved, caller die or fail after script, build it again
+	// from what script kept with the claim
+	c := &redisClaim{}
+	if ok, err = r.GetJSON(requestIDKey(agent, requestID), c); err != nil || !ok {
+		return nil, err
+	}
+
+	return c.outcome()
+}
+
+// redisClaim value of request id key, op before script and what it wrote,
+// with the script result kept in same step as wallet change
+type redisClaim struct {
+	Claim struct {
+		Outcome *Outcome             `json:"outcome"`
+		Data    []*modules.RedisData `json:"data"`
+	} `json:"claim"`
+	Result []string `json:"result"`
+}
+
+// claimWith set claim of op on request id key of rd, from and to of transfer
+func claimWith(op *Operation, rds ...*modules.RedisData) error {
+	c := &redisClaim{}
+	c.Claim.Outcome = newOutcome(op, 0, nil)
+	c.Claim.Data = rds
+
+	j, err := json.Marshal(c.Claim)
+	if err != nil {
+		return err
+	}
+	rds[0].Claim = string(j)
+
+	return nil
+}
+
+// outcome of claim as if store run after script, nil while script not finish
+func (c *redisClaim) outcome() (*Outcome, error) {
+	o, rds := c.Claim.Outcome, c.Claim.Data
+	if o == nil || o.Op == nil || len(rds) == 0 || len(c.Result) == 0 {
+		return nil, nil
+	}
+
+	// last change is time when set, json give it back as text
+	for _, rd := range rds {
+		if v, ok := rd.HashMap[kredis.RedisHashlastChangeKey].(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, err
+			}
+			rd.HashMap[kredis.RedisHashlastChangeKey] = t
+		}
+	}
+
+	status, err := kredis.ClaimResult(c.Result, rds...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = redisFinish(o.Op, status, rds...)
+	o.Status = StatusOf(err)
+	if o.Status >= http.StatusInternalServerError {
+		return nil, err
+	}
+	if err != nil {
+		o.Error = err.Error()
+	}
+
+	return o, nil
+}
+
+func newRedisData(op *Operation) *modules.RedisData {
+	rd := &modules.RedisData{
+		HashMap:      make(map[string]interface{}),
+		OrderID:      guuid.New().String(),
+		RequestID:    op.RequestID,
+		RequestIDKey: requestIDKey(op.Agent, op.RequestID),
+		RequestIDTTL: requestIDTTL(op.Agent),
+		OpType:       op.OpType,
+		FXCurrency:   op.FXCurrency,
+		FXAmount:     op.FXAmount,
+		Rate:         op.Rate,
+	}
+
+	// op log is kept long and copied to postgres, player token stay out
+	if op.post != nil {
+		p := *op.post
+		p.Token = ""
+		rd.PostData = &p
+	}
+
+	rd.UserKey = BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, &op.Agent, &op.User, &op.Currency)
+	rd.WallteOpKey = BuildRedisDataWithDelimiter(kredis.RediswallteOpsPerfix, &op.Agent, &op.User, &op.Currency)
+	rd.OrderIdxKey = BuildRedisDataWithDelimiter(kredis.RedisOrderIdxPerfix, &op.Agent, &op.User, &op.Currency)
+	rd.OrderKey = BuildRedisDataWithDelimiter(kredis.RedisOrderPerfix, &rd.OrderID)
+	rd.HashMap[kredis.RedisHashlastChangeKey] = time.Now()
+
+	return rd
+}
+
+// redisMissing error for wallet not found, user may still have other currency
+func redisMissing(op *Operation) error {
+	toCurrency, _ := op.Credit()
+	users := [][2]string{{op.User, op.Currency}}
+	if op.ToUser != "" {
+		users = append(users, [2]string{op.ToUser, toCurrency})
+	}
+
+	r := kredis.GetRedisClientInstance()
+	for _, uc := range users {
+		u := uc[0]
+		n, err := r.Exists(BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, &op.Agent, &u, uc[1]))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		keys := []string{}
+		for _, c := range money.Currencies() {
+			keys = append(keys, BuildRedisDataWithDelimiter(kredis.RedisUserPerfix, &op.Agent, &u, c))
+		}
+		n, err = r.Exists(keys...)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrCurrencyMismatch
+		}
+		return ErrUserNotFound
+	}
+
+	return ErrUserNotFound
+}
+
+// redisFinish set op from script status and what script wrote, from and to
+// of transfer
+func redisFinish(op *Operation, status int, rds ...*modules.RedisData) error {
+	if err := redisOpResult(op, rds[0], status); err != nil {
+		return err
+	}
+
+	switch op.OpType {
+	case modules.WalletTransferOut:
+		to := rds[1]
+		op.ToOrderID = to.OrderID
+		op.ToBeforeAmount = to.OpAmtBefor
+		op.ToAfterAmount = to.OpAmtAfter
+	case modules.WalletHold:
+		op.HoldID = rds[0].HoldID
+	}
+
+	return nil
+}
+
+func redisOpResult(op *Operation, rd *modules.RedisData, status int) error {
+	switch status {
+	case kredis.WalletOpRequestRepeat:
+		return ErrRequestIDRepeat
+	case kredis.WalletOpNoWallet:
+		return redisMissing(op)
+	case kredis.WalletOpNotEnough:
+		return ErrNotEnoughBalance
+	case kredis.WalletOpNoOrder:
+		return ErrOrderNotFound
+	case kredis.WalletOpRolledBack:
+		return ErrAlreadyRollback
+	case kredis.WalletOpNoRollback:
+		return ErrNotRollbackable
+	case kredis.WalletOpAmountError:
+		if op.OpType == modules.WalletRollback {
+			return ErrRollbackAmount
+		}
+		return ErrHoldAmount
+	case kredis.WalletOpNoHold:
+		return ErrHoldNotFound
+	case kredis.WalletOpHoldSettled:
+		return ErrHoldSettled
+	case kredis.WalletOpHoldExpired:
+		return ErrHoldExpired
+	}
+
+	op.OrderID = rd.OrderID
+	op.RefOrderID = rd.RefOrderID
+	op.BeforeAmount = rd.OpAmtBefor
+	op.AfterAmount = rd.OpAmtAfter
+	op.UpdateAt = rd.HashMap[kredis.RedisHashlastChangeKey].(time.Time)
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package wallet
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+)
+
+// setRedisBalance fresh redis with one user wallet
+func setRedisBalance(agent, user, currency string, amount money.Amount) {
+	testRedis.FlushAll()
+	testRedis.HSet(redisUserKey(agent, user, currency), "wallet", strconv.FormatInt(int64(amount), 10))
+}
+
+// redisValues every value kept in redis, with hash fields, list items and
+// stream fields
+func redisValues(t *testing.T) []string {
+	t.Helper()
+
+	var values []string
+	for _, k := range testRedis.Keys() {
+		switch testRedis.Type(k) {
+		case "string":
+			v, _ := testRedis.Get(k)
+			values = append(values, v)
+		case "hash":
+			fields, _ := testRedis.HKeys(k)
+			for _, f := range fields {
+				values = append(values, testRedis.HGet(k, f))
+			}
+		case "list":
+			l, _ := testRedis.List(k)
+			values = append(values, l...)
+		case "stream":
+			entries, _ := testRedis.Stream(k)
+			for _, e := range entries {
+				values = append(values, e.Values...)
+			}
+		}
+	}
+	return values
+}
+
+func TestRedisOpLogWithoutToken(t *testing.T) {
+	setRedisBalance(testAgent, "1", "TWD", money.FromInt(100))
+
+	p := testPost(t, "1", "TWD", "req", money.FromInt(30), &modules.PostDeductv2{GameID: 1})
+	if _, _, err := Process(GetStore(RedisBackend), p); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := false
+	for _, v := range redisValues(t) {
+		if strings.Contains(v, p.Token) {
+			t.Errorf("player token kept in redis: %.80s", v)
+		}
+		logged = logged || strings.Contains(v, `"gameid":1`)
+	}
+	if !logged {
+		t.Error("no op log with post data found")
+	}
+}
+
+// crashStore redis store whose process die after wallet script, before the
+// outcome is saved
+type crashStore struct {
+	Store
+}
+
+func (s *crashStore) SaveOutcome(o *Outcome) error {
+	panic("process killed")
+}
+
+// processCrash run p on crashStore, the panic stand for the killed process
+func processCrash(t *testing.T, p *modules.PostDatav2) {
+	t.Helper()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("process not killed before outcome saved")
+		}
+	}()
+	Process(&crashStore{GetStore(RedisBackend)}, p)
+}
+
+func TestRedisReplayAfterCrash(t *testing.T) {
+	tests := []struct {
+		name       string
+		amount     money.Amount
+		detail     interface{}
+		wantErr    error
+		wantStatus int
+		want       money.Amount
+	}{
+		{"deduct", money.FromInt(30), &modules.PostDeductv2{GameID: 1}, nil, http.StatusOK, money.FromInt(70)},
+		{"deduct over balance", money.FromInt(101), &modules.PostDeductv2{GameID: 1}, ErrNotEnoughBalance, http.StatusConflict, money.FromInt(100)},
+		{"transfer", money.FromInt(40), &modules.PostTransferv2{ToUser: "2"}, nil, http.StatusOK, money.FromInt(60)},
+		{"transfer to user without wallet", money.FromInt(40), &modules.PostTransferv2{ToUser: "3"}, ErrUserNotFound, http.StatusNotFound, money.FromInt(100)},
+		{"hold", money.FromInt(30), &modules.PostHoldv2{GameID: 1}, nil, http.StatusOK, money.FromInt(70)},
+	}
+
+	s := GetStore(RedisBackend)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRedisBalance(testAgent, "1", "TWD", money.FromInt(100))
+			testRedis.HSet(redisUserKey(testAgent, "2", "TWD"), "wallet", "0")
+
+			processCrash(t, testPost(t, "1", "TWD", "req", tt.amount, tt.detail))
+			if testRedis.Exists(outcomeKey(testAgent, "req")) {
+				t.Fatal("outcome saved before crash")
+			}
+
+			op, status, err := Process(s, testPost(t, "1", "TWD", "req", tt.amount, tt.detail))
+			if err != tt.wantErr {
+				t.Fatalf("err %v, want %v", err, tt.wantErr)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("status %d, want %d", status, tt.wantStatus)
+			}
+			if !op.Replay {
+				t.Error("retry not replayed")
+			}
+			if err == nil && (op.OrderID == "" || op.AfterAmount != tt.want) {
+				t.Errorf("replay order %q after %s, want after %s", op.OrderID, op.AfterAmount, tt.want)
+			}
+
+			b, err := s.GetBalance(testAgent, "1", "TWD")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b != tt.want {
+				t.Errorf("balance %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	guuid "github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	kredis "github.com/kyos0109/test-wallet/redis"
+)
+
+// opStreamCheckpoint checkpoint name of redis op stream sync
+const opStreamCheckpoint = "redis-op-stream"
+
+// opLog op log json written by redis wallet scripts
+type opLog struct {
+	UserKey    string
+	OrderID    string
+	RequestID  string
+	OpType     modules.WalletOps
+	RefOrderID string
+	HoldID     string
+	ExpireAt   *time.Time
+	FXCurrency string
+	FXAmount   money.Amount
+	Rate       money.Rate
+	OpAmtBefor money.Amount
+	OpAmtAfter money.Amount
+	Held       money.Amount
+	Captured   money.Amount
+	HashMap    struct {
+		LastChange string `json:"lastChange"`
+	}
+	PostData *struct {
+		// empty detail may come back from lua as [], decode later
+		Detail json.RawMessage `json:"detail"`
+	}
+}
+
+type opLogDetail struct {
+	GameID int    `json:"gameid"`
+	ToUser string `json:"touser"`
+}
+
+// SyncWorker persist redis op stream into postgres every interval
+func SyncWorker(ctx context.Context) {
+	log.Print("start sync worker...")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Print("stop sync worker...")
+			return
+		case <-time.After(walletConfig.SyncInterval):
+			for {
+				n, err := SyncOnce(walletConfig.SyncBatch)
+				if err != nil {
+					log.Println("sync op stream:", err)
+				}
+				if err != nil || n < walletConfig.SyncBatch {
+					break
+				}
+			}
+		}
+	}
+}
+
+// SyncOnce persist at most limit op logs after checkpoint, each op log and
+// the checkpoint commit together so restart neither lose nor repeat one.
+// return count done, stop at first failed op log. op log failed
+// SyncMaxAttempts times go to dead letter list and checkpoint move past
+// it, so one bad op can not block the stream
+func SyncOnce(limit int) (int, error) {
+	db := database.GetDBInstance()
+	r := kredis.GetRedisClientInstance()
+
+	cp := &modules.SyncCheckpoint{}
+	if err := db.Conn().Limit(1).Find(cp, "name = ?", opStreamCheckpoint).Error; err != nil {
+		return 0, err
+	}
+
+	entries, err := r.OpStreamRange(cp.LastID, int64(limit))
+	if err != nil {
+		return 0, err
+	}
+
+	done := []string{}
+	defer func() {
+		// persisted entries no longer needed in redis
+		if len(done) > 0 {
+			if err := r.OpStreamDel(done...); err != nil {
+				log.Println("sync op stream del:", err)
+			}
+		}
+	}()
+
+	for _, e := range entries {
+		tx := db.Conn().Begin()
+
+		cp, err := lockCheckpoint(tx)
+		if err != nil {
+			tx.Rollback()
+			return len(done), err
+		}
+
+		// other instance got here first
+		if !streamIDAfter(e.ID, cp.LastID) {
+			tx.Rollback()
+			done = append(done, e.ID)
+			continue
+		}
+
+		if err := persistOpLog(tx, e.ID, e.Log); err != nil {
+			tx.Rollback()
+			err = errors.New("op " + e.ID + ": " + err.Error())
+
+			dead, derr := deadLetter(r, e, err)
+			if derr != nil {
+				return len(done), derr
+			}
+			if !dead {
+				return len(done), err
+			}
+			log.Println("sync op stream dead letter", err)
+			if err := skipOpLog(db.Conn(), e.ID); err != nil {
+				return len(done), err
+			}
+			done = append(done, e.ID)
+			continue
+		}
+
+		cp.LastID = e.ID
+		cp.UpdateAt = time.Now()
+		if err := tx.Save(cp).Error; err != nil {
+			tx.Rollback()
+			return len(done), err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return len(done), err
+		}
+		done = append(done, e.ID)
+	}
+
+	return len(done), nil
+}
+
+// deadLetter count failed sync of op log, true once it failed max attempts
+// and is pushed to dead letter list
+func deadLetter(r *kredis.RedisClient, e kredis.OpStreamEntry, cause error) (bool, error) {
+	n, err := r.OpStreamFail(e.ID)
+	if err != nil {
+		return false, err
+	}
+	if n < int64(walletConfig.SyncMaxAttempts) {
+		return false, nil
+	}
+
+	return true, r.OpStreamDead(e, cause.Error())
+}
+
+// skipOpLog move checkpoint past op log without persisting it
+func skipOpLog(db *gorm.DB, id string) error {
+	tx := db.Begin()
+
+	cp, err := lockCheckpoint(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if streamIDAfter(id, cp.LastID) {
+		cp.LastID = id
+		cp.UpdateAt = time.Now()
+		if err := tx.Save(cp).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func lockCheckpoint(tx *gorm.DB) (*modules.SyncCheckpoint, error) {
+	cp := &modules.SyncCheckpoint{Name: opStreamCheckpoint, UpdateAt: time.Now()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(cp).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(cp, "name = ?", opStreamCheckpoint).Error; err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// persistOpLog write order, wallet, hold and ledger of one op log in tx,
+// op log with order already in postgres is skipped. op delta is applied on
+// postgres balance, so change made on postgres side is kept, a balance not
+// at redis before amount is recorded as drift
+func persistOpLog(tx *gorm.DB, streamID, raw string) error {
+	l := &opLog{}
+	if err := json.Unmarshal([]byte(raw), l); err != nil {
+		return err
+	}
+
+	d := &opLogDetail{}
+	if l.PostData != nil {
+		json.Unmarshal(l.PostData.Detail, d)
+	}
+
+	agent, user, currency, ok := splitUserKey(l.UserKey)
+	if !ok {
+		return errors.New("bad user key " + l.UserKey)
+	}
+
+	oid, err := guuid.Parse(l.OrderID)
+	if err != nil {
+		return err
+	}
+
+	w, err := lockWallet(tx, agent, user, currency)
+	if err != nil {
+		return err
+	}
+	delta := l.OpAmtAfter - l.OpAmtBefor
+
+	createAt, _ := time.Parse(time.RFC3339Nano, l.HashMap.LastChange)
+	order := &modules.Order{
+		ID:           oid,
+		UserID:       w.UserID,
+		WalletID:     w.ID,
+		GameID:       d.GameID,
+		OpType:       l.OpType,
+		RequestID:    l.RequestID,
+		BeforeAmount: w.Amount,
+		AfterAmount:  w.Amount + delta,
+		Status:       modules.OrderOk,
+		CreateAt:     createAt,
+		UpdateAt:     time.Now(),
+	}
+	if ref, err := guuid.Parse(l.RefOrderID); err == nil {
+		order.RefOrderID = &ref
+	}
+	fxOrder(order, l.FXCurrency, l.FXAmount, l.Rate)
+	if l.OpType == modules.WalletCapture || l.OpType == modules.WalletRelease {
+		order.Comment = string(settleStatus(l))
+	}
+
+	r := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(order)
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected == 0 {
+		return nil
+	}
+
+	if w.Amount != l.OpAmtBefor {
+		log.Println("sync drift of wallet", w.ID, "redis", l.OpAmtBefor, "postgres", w.Amount)
+		drift := &modules.SyncDrift{
+			OrderID:        oid,
+			WalletID:       w.ID,
+			StreamID:       streamID,
+			RedisBefore:    l.OpAmtBefor,
+			PostgresBefore: w.Amount,
+			CreateAt:       order.UpdateAt,
+		}
+		if err := tx.Create(drift).Error; err != nil {
+			return err
+		}
+	}
+
+	op := &Operation{
+		Agent:        agent,
+		User:         user,
+		Currency:     currency,
+		OpType:       l.OpType,
+		Amount:       delta.Abs(),
+		ToUser:       d.ToUser,
+		BeforeAmount: order.BeforeAmount,
+		AfterAmount:  order.AfterAmount,
+		FXCurrency:   l.FXCurrency,
+		FXAmount:     l.FXAmount,
+		Rate:         l.Rate,
+	}
+	lines := op.Lines()
+
+	w.Amount = order.AfterAmount
+	w.UpdateAt = order.UpdateAt
+
+	switch l.OpType {
+	case modules.WalletHold:
+		if err := syncHold(tx, l, w, order); err != nil {
+			return err
+		}
+	case modules.WalletCapture, modules.WalletRelease:
+		if err := syncSettle(tx, l, w); err != nil {
+			return err
+		}
+		op.Amount = l.Held
+		if l.OpType == modules.WalletCapture {
+			op.Amount = l.Captured
+		}
+		lines = op.Lines()
+	case modules.WalletRollback:
+		if lines, err = syncRollback(tx, op, order); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Save(w).Error; err != nil {
+		return err
+	}
+
+	return ledger.Post(tx, &oid, l.OpType, lines)
+}
+
+// lockWallet lock postgres wallet by redis user key parts, user must be in
+// postgres under that agent, wallet is created when missing
+func lockWallet(tx *gorm.DB, agent, user, currency string) (*modules.Wallet, error) {
+	uid, err := strconv.Atoi(user)
+	if err != nil {
+		return nil, errors.New("user not number " + user)
+	}
+	aid, err := strconv.Atoi(agent)
+	if err != nil {
+		return nil, errors.New("agent not number " + agent)
+	}
+
+	var n int64
+	if err := tx.Model(&modules.User{}).Where("id = ? AND agent_id = ?", uid, aid).Count(&n).Error; err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("no user " + user + " of agent " + agent + " in postgres")
+	}
+
+	w := &modules.Wallet{UserID: uid, Currency: currency, UpdateAt: time.Now()}
+	r := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND currency = ?", uid, currency).Limit(1).Find(w)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	if r.RowsAffected == 0 {
+		if err := tx.Create(w).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+func syncHold(tx *gorm.DB, l *opLog, w *modules.Wallet, order *modules.Order) error {
+	id, err := guuid.Parse(l.HoldID)
+	if err != nil {
+		return err
+	}
+
+	amount := l.OpAmtBefor - l.OpAmtAfter
+	w.Held += amount
+
+	h := &modules.Hold{
+		ID:       id,
+		UserID:   w.UserID,
+		WalletID: w.ID,
+		OrderID:  order.ID,
+		GameID:   order.GameID,
+		Amount:   amount,
+		Status:   modules.HoldHeld,
+		CreateAt: order.CreateAt,
+		UpdateAt: order.UpdateAt,
+	}
+	if l.ExpireAt != nil {
+		h.ExpireAt = *l.ExpireAt
+	}
+
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(h).Error
+}
+
+func syncSettle(tx *gorm.DB, l *opLog, w *modules.Wallet) error {
+	w.Held -= l.Held
+
+	return tx.Model(&modules.Hold{}).Where("id = ?", l.HoldID).
+		Updates(map[string]interface{}{"status": settleStatus(l), "captured": l.Captured, "update_at": time.Now()}).Error
+}
+
+// settleStatus hold status after settle op, expiry use expire request id
+func settleStatus(l *opLog) modules.HoldStatus {
+	switch {
+	case l.OpType == modules.WalletCapture:
+		return modules.HoldCaptured
+	case strings.HasPrefix(l.RequestID, kredis.HoldExpire+kredis.RedisDelimiter):
+		return modules.HoldExpired
+	}
+	return modules.HoldReleased
+}
+
+// syncRollback mark origin order and return reverse lines of it, origin
+// not in postgres get lines of a plain store or deduct
+func syncRollback(tx *gorm.DB, op *Operation, order *modules.Order) ([]modules.JournalLine, error) {
+	ref := &modules.Order{}
+	r := tx.Where("id = ?", order.RefOrderID).Limit(1).Find(ref)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		o := *op
+		o.OpType = modules.WalletDeduct
+		if op.AfterAmount < op.BeforeAmount {
+			o.OpType = modules.WalletStore
+		}
+		return ledger.Reverse(o.Lines()), nil
+	}
+
+	if err := tx.Model(ref).Updates(map[string]interface{}{"status": modules.OrderRollback, "update_at": time.Now()}).Error; err != nil {
+		return nil, err
+	}
+
+	lines, err := refLines(tx, op, ref)
+	if err != nil {
+		return nil, err
+	}
+	return ledger.Reverse(lines), nil
+}
+
+// streamIDAfter stream id a is after b, empty b is before every id
+func streamIDAfter(a, b string) bool {
+	if b == "" {
+		return true
+	}
+
+	am, as := splitStreamID(a)
+	bm, bs := splitStreamID(b)
+	if am != bm {
+		return am > bm
+	}
+	return as > bs
+}
+
+func splitStreamID(id string) (uint64, uint64) {
+	ms, seq := id, ""
+	if i := strings.IndexByte(id, '-'); i >= 0 {
+		ms, seq = id[:i], id[i+1:]
+	}
+	m, _ := strconv.ParseUint(ms, 10, 64)
+	s, _ := strconv.ParseUint(seq, 10, 64)
+	return m, s
+}
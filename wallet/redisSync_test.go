@@ -0,0 +1,227 @@
+package wallet
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kyos0109/test-wallet/config"
+	"github.com/kyos0109/test-wallet/database"
+	"github.com/kyos0109/test-wallet/ledger"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	kredis "github.com/kyos0109/test-wallet/redis"
+)
+
+// testDSNEnv scratch postgres database for tests needing one, tests skip
+// without it. its schema is dropped and made again, never point at real data.
+// database and wallet tests share it, run them with go test -p 1
+const testDSNEnv = "WALLET_TEST_DATABASE_DSN"
+
+// testPostgres postgres of testDSNEnv with fresh schema and agent testAgent,
+// redis emptied too. skip test without it
+func testPostgres(t *testing.T) *database.DBConn {
+	t.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv, "not set")
+	}
+
+	ctx := context.Background()
+	database.InitWithCtx(&ctx)
+	c := config.Default().Database
+	c.DSN = dsn
+	database.InitWithConfig(&c)
+
+	db := database.GetDBInstance()
+	if _, err := db.MigrateDown(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	aid, _ := strconv.Atoi(testAgent)
+	if err := db.Conn().Exec("INSERT INTO agents (id, name) VALUES (?, ?)", aid, "test").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	testRedis.FlushAll()
+	return db
+}
+
+// setPgBalance postgres user of testAgent with wallet of amount, opening
+// entry keep ledger matching it
+func setPgBalance(t *testing.T, db *database.DBConn, user, currency string, amount money.Amount) {
+	t.Helper()
+
+	uid, _ := strconv.Atoi(user)
+	aid, _ := strconv.Atoi(testAgent)
+	now := time.Now()
+
+	conn := db.Conn()
+	var n int64
+	if err := conn.Model(&modules.User{}).Where("id = ?", uid).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		if err := conn.Create(&modules.User{ID: uid, AgentID: aid, Status: true, UpdateAt: now}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := conn.Create(&modules.Wallet{UserID: uid, Amount: amount, Currency: currency, UpdateAt: now}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Post(conn, nil, modules.WalletOpening, ledger.Opening(ledger.Wallet(testAgent, user, currency), currency, amount)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkPgWallet postgres wallet balance and held, ledger matching both and
+// no entry out of balance
+func checkPgWallet(t *testing.T, db *database.DBConn, user, currency string, amount, held money.Amount) {
+	t.Helper()
+
+	conn := db.Conn()
+	uid, _ := strconv.Atoi(user)
+	w := &modules.Wallet{}
+	if err := conn.Where("user_id = ? AND currency = ?", uid, currency).First(w).Error; err != nil {
+		t.Fatalf("wallet of %s %s: %v", user, currency, err)
+	}
+	if w.Amount != amount || w.Held != held {
+		t.Errorf("postgres wallet %s %s is %s held %s, want %s held %s", user, currency, w.Amount, w.Held, amount, held)
+	}
+
+	if l, err := ledger.Balance(conn, ledger.Wallet(testAgent, user, currency)); err != nil || l != w.Amount {
+		t.Errorf("ledger wallet %s %s is %s, wallet %s: %v", user, currency, l, w.Amount, err)
+	}
+	if l, err := ledger.Balance(conn, ledger.Held(testAgent, user, currency)); err != nil || l != w.Held {
+		t.Errorf("ledger held %s %s is %s, held %s: %v", user, currency, l, w.Held, err)
+	}
+	if ids, err := ledger.Unbalanced(conn); err != nil || len(ids) > 0 {
+		t.Errorf("unbalanced entries %v: %v", ids, err)
+	}
+}
+
+// checkpointID last op stream id synced
+func checkpointID(t *testing.T, db *database.DBConn) string {
+	t.Helper()
+
+	cp := &modules.SyncCheckpoint{}
+	if err := db.Conn().Limit(1).Find(cp, "name = ?", opStreamCheckpoint).Error; err != nil {
+		t.Fatal(err)
+	}
+	return cp.LastID
+}
+
+// streamIDs ids of op stream entries, oldest first
+func streamIDs(t *testing.T) []string {
+	t.Helper()
+
+	entries, err := testRedis.Stream(kredis.RedisOpStreamKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestSyncOnceCheckpoint(t *testing.T) {
+	db := testPostgres(t)
+	setRedisBalance(testAgent, "1", "TWD", money.FromInt(100))
+	setPgBalance(t, db, "1", "TWD", money.FromInt(100))
+
+	s := GetStore(RedisBackend)
+	for i, amount := range []int64{30, 20, 10} {
+		p := testPost(t, "1", "TWD", "req"+strconv.Itoa(i), money.FromInt(amount), &modules.PostDeductv2{GameID: 1})
+		if _, _, err := Process(s, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := streamIDs(t)
+	if len(ids) != 3 {
+		t.Fatalf("op stream %d, want 3", len(ids))
+	}
+
+	// each op log commit with checkpoint, batch stop at limit
+	if n, err := SyncOnce(2); err != nil || n != 2 {
+		t.Fatalf("synced %d, want 2: %v", n, err)
+	}
+	if id := checkpointID(t, db); id != ids[1] {
+		t.Errorf("checkpoint %s, want %s", id, ids[1])
+	}
+	checkPgWallet(t, db, "1", "TWD", money.FromInt(50), 0)
+
+	if n, err := SyncOnce(2); err != nil || n != 1 {
+		t.Fatalf("synced %d, want 1: %v", n, err)
+	}
+	if id := checkpointID(t, db); id != ids[2] {
+		t.Errorf("checkpoint %s, want %s", id, ids[2])
+	}
+	if left := streamIDs(t); len(left) != 0 {
+		t.Errorf("synced op logs left in stream %v", left)
+	}
+	checkPgWallet(t, db, "1", "TWD", money.FromInt(40), 0)
+
+	// op log given again after its order persisted is not applied twice
+	logs, err := kredis.GetRedisClientInstance().UserOpLogs(BuildRedisDataWithDelimiter(kredis.RediswallteOpsPerfix, testAgent, "1", "TWD"), 0, 1)
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("op logs %v: %v", logs, err)
+	}
+	if _, err := testRedis.XAdd(kredis.RedisOpStreamKey, "*", []string{"op", logs[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := SyncOnce(2); err != nil || n != 1 {
+		t.Fatalf("synced %d, want 1: %v", n, err)
+	}
+	checkPgWallet(t, db, "1", "TWD", money.FromInt(40), 0)
+
+	var orders int64
+	if err := db.Conn().Model(&modules.Order{}).Count(&orders).Error; err != nil || orders != 3 {
+		t.Errorf("orders %d, want 3: %v", orders, err)
+	}
+}
+
+func TestSyncOnceDeadLetter(t *testing.T) {
+	db := testPostgres(t)
+	setRedisBalance(testAgent, "1", "TWD", money.FromInt(100))
+	setPgBalance(t, db, "1", "TWD", money.FromInt(100))
+	// user only in redis, its op log can never persist
+	testRedis.HSet(redisUserKey(testAgent, "9", "TWD"), "wallet", strconv.FormatInt(int64(money.FromInt(100)), 10))
+
+	s := GetStore(RedisBackend)
+	for _, user := range []string{"9", "1"} {
+		p := testPost(t, user, "TWD", "req"+user, money.FromInt(30), &modules.PostDeductv2{GameID: 1})
+		if _, _, err := Process(s, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := streamIDs(t)
+
+	// failed op log block the stream till max attempts
+	for i := 1; i < walletConfig.SyncMaxAttempts; i++ {
+		if n, err := SyncOnce(10); err == nil || n != 0 {
+			t.Fatalf("attempt %d synced %d: %v, want error", i, n, err)
+		}
+		if id := checkpointID(t, db); id != "" {
+			t.Fatalf("attempt %d moved checkpoint to %s", i, id)
+		}
+	}
+
+	if n, err := SyncOnce(10); err != nil || n != 2 {
+		t.Fatalf("synced %d, want 2: %v", n, err)
+	}
+	if id := checkpointID(t, db); id != ids[1] {
+		t.Errorf("checkpoint %s, want %s", id, ids[1])
+	}
+	if n, err := kredis.GetRedisClientInstance().OpStreamDeadLen(); err != nil || n != 1 {
+		t.Errorf("dead letters %d, want 1: %v", n, err)
+	}
+	checkPgWallet(t, db, "1", "TWD", money.FromInt(70), 0)
+}
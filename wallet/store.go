@@ -0,0 +1,330 @@
+package wallet
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kyos0109/test-wallet/agent"
+	"github.com/kyos0109/test-wallet/errcode"
+	"github.com/kyos0109/test-wallet/fx"
+	"github.com/kyos0109/test-wallet/modules"
+	"github.com/kyos0109/test-wallet/money"
+	"github.com/kyos0109/test-wallet/session"
+)
+
+// backend name
+const (
+	RedisBackend    = "redis"
+	PostgresBackend = "postgres"
+)
+
+// wallet business error, same for every backend
+var (
+	ErrInterface        = errcode.New(errcode.UnknownAction, http.StatusBadRequest, "wallet action not allowed")
+	ErrInvalidAmount    = errcode.New(errcode.InvalidAmount, http.StatusBadRequest, "amount must be positive")
+	ErrInvalidRequestID = errcode.New(errcode.InvalidRequestID, http.StatusBadRequest, "invalid request id")
+	ErrRequestIDRepeat  = errcode.New(errcode.DuplicateRequest, http.StatusPreconditionFailed, "Request ID Repeat")
+	ErrUserNotFound     = errcode.New(errcode.UserNotFound, http.StatusNotFound, "user not found")
+	ErrNotEnoughBalance = errcode.New(errcode.InsufficientFunds, http.StatusConflict, "Not Enough Balance")
+	ErrRollbackRef      = errcode.New(errcode.RollbackRef, http.StatusBadRequest, "rollback need refrequestid or reforderid")
+	ErrOrderNotFound    = errcode.New(errcode.OrderNotFound, http.StatusNotFound, "order not found")
+	ErrAlreadyRollback  = errcode.New(errcode.AlreadyRollback, http.StatusConflict, "order already rollback")
+	ErrNotRollbackable  = errcode.New(errcode.NotRollbackable, http.StatusConflict, "order can not rollback")
+	ErrRollbackAmount   = errcode.New(errcode.RollbackAmount, http.StatusBadRequest, "rollback amount not match order")
+	ErrAmountPrecision  = errcode.New(errcode.AmountPrecision, http.StatusBadRequest, "amount precision over currency")
+	ErrCurrency         = errcode.New(errcode.CurrencyNotSupport, http.StatusBadRequest, "currency not support")
+	ErrCurrencyMismatch = errcode.New(errcode.CurrencyMismatch, http.StatusBadRequest, "user has no wallet of currency")
+	ErrNoRate           = errcode.New(errcode.NoRate, http.StatusBadRequest, "no rate for currency pair")
+	ErrTransferSelf     = errcode.New(errcode.TransferSelf, http.StatusBadRequest, "can not transfer to same user")
+	ErrTransferTo       = errcode.New(errcode.TransferTo, http.StatusBadRequest, "transfer need touser, or fromagent to user")
+	ErrHoldTTL          = errcode.New(errcode.HoldTTL, http.StatusBadRequest, "hold ttl out of range")
+	ErrHoldNotFound     = errcode.New(errcode.HoldNotFound, http.StatusNotFound, "hold not found")
+	ErrHoldSettled      = errcode.New(errcode.HoldSettled, http.StatusConflict, "hold already settled")
+	ErrHoldExpired      = errcode.New(errcode.HoldExpired, http.StatusConflict, "hold expired")
+	ErrHoldAmount       = errcode.New(errcode.HoldAmount, http.StatusBadRequest, "amount not match hold")
+	ErrBusy             = errcode.New(errcode.Busy, http.StatusServiceUnavailable, "wallet busy, try again")
+)
+
+// businessErrors error kept in outcome, found again by message on replay
+var businessErrors = []error{
+	ErrInvalidAmount, ErrUserNotFound, ErrNotEnoughBalance, ErrRollbackRef, ErrOrderNotFound,
+	ErrAlreadyRollback, ErrNotRollbackable, ErrRollbackAmount, ErrAmountPrecision, ErrCurrency,
+	ErrCurrencyMismatch, ErrNoRate, ErrTransferSelf, ErrTransferTo, ErrHoldTTL, ErrHoldNotFound, ErrHoldSettled,
+	ErrHoldExpired, ErrHoldAmount,
+}
+
+// Store wallet storage backend
+type Store interface {
+	// GetBalance current balance of agent user wallet in currency
+	GetBalance(agent, user, currency string) (money.Amount, error)
+	// Balance wallet state of agent user in currency, with held, last
+	// change and last game
+	Balance(agent, user, currency string) (*Balance, error)
+	// ApplyDelta check request id, check balance, change wallet and record
+	// order as one operation, fill op result
+	ApplyDelta(op *Operation) error
+	// Rollback reverse the order op refer to and record a rollback order
+	// linked to it, same one operation rule as ApplyDelta
+	Rollback(op *Operation) error
+	// Transfer move amount from op user to op to user in op currency, both
+	// wallets and paired orders change together or not at all
+	Transfer(op *Operation) error
+	// Hold move amount from wallet to held until op expire at
+	Hold(op *Operation) error
+	// Settle capture or release op hold id, by op type
+	Settle(op *Operation) error
+	// SearchOrders orders matching query after its cursor, newest first, at
+	// most limit plus one
+	SearchOrders(q *OrderQuery) ([]*OrderRecord, error)
+	// ExportOrders pass every order matching query to fn as read, oldest
+	// first, cursor and limit not used. fn error stop export
+	ExportOrders(q *OrderQuery, fn func(*OrderRecord) error) error
+	// ExpireHolds release at most limit holds over expire time, return
+	// count released
+	ExpireHolds(limit int) (int, error)
+	// SaveOutcome keep outcome of agent request id for replay, as long as
+	// the id
+	SaveOutcome(o *Outcome) error
+	// FindOutcome kept outcome of agent request id, nil if none
+	FindOutcome(agent, requestID string) (*Outcome, error)
+}
+
+// Operation one wallet change, request and result
+type Operation struct {
+	Agent        string            `json:"agent"`
+	User         string            `json:"user"`
+	RequestID    string            `json:"requestid"`
+	OpType       modules.WalletOps `json:"optype"`
+	Amount       money.Amount      `json:"amount"`
+	Currency     string            `json:"currency"`
+	GameID       int               `json:"gameid,omitempty"`
+	RefRequestID string            `json:"refrequestid,omitempty"`
+	RefOrderID   string            `json:"reforderid,omitempty"`
+	OrderID      string            `json:"orderid"`
+	BeforeAmount money.Amount      `json:"before"`
+	AfterAmount  money.Amount      `json:"after"`
+	UpdateAt     time.Time         `json:"updateat"`
+
+	ToUser         string       `json:"touser,omitempty"`
+	ToOrderID      string       `json:"toorderid,omitempty"`
+	ToBeforeAmount money.Amount `json:"tobefore,omitempty"`
+	ToAfterAmount  money.Amount `json:"toafter,omitempty"`
+
+	HoldID   string     `json:"holdid,omitempty"`
+	ExpireAt *time.Time `json:"expireat,omitempty"`
+
+	FXCurrency string       `json:"fxcurrency,omitempty"`
+	FXAmount   money.Amount `json:"fxamount,omitempty"`
+	Rate       money.Rate   `json:"rate,omitempty"`
+
+	// Replay true when answer is kept outcome of same request id
+	Replay bool `json:"replay,omitempty"`
+
+	// kept outcome saved by store in same transaction as wallet change
+	kept bool
+	post *modules.PostDatav2
+}
+
+var stores = map[string]Store{
+	RedisBackend:    &redisStore{},
+	PostgresBackend: &pgStore{},
+}
+
+// GetStore backend by name
+func GetStore(name string) Store {
+	return stores[name]
+}
+
+// NewOperation build operation from post data
+func NewOperation(p *modules.PostDatav2) (*Operation, error) {
+	op := &Operation{
+		Agent:     p.Agent,
+		User:      p.User,
+		RequestID: p.RequestID,
+		Amount:    p.Amount,
+		Currency:  p.Currency,
+		post:      p,
+	}
+
+	switch d := p.Detail.(type) {
+	case *modules.PostStorev2:
+		op.OpType = modules.WalletStore
+	case *modules.PostDeductv2:
+		op.OpType = modules.WalletDeduct
+		op.GameID = d.GameID
+	case *modules.PostRollbackv2:
+		op.OpType = modules.WalletRollback
+		op.RefRequestID = d.RefRequestID
+		op.RefOrderID = d.RefOrderID
+		if op.RefRequestID == "" && op.RefOrderID == "" {
+			return nil, ErrRollbackRef
+		}
+	case *modules.PostTransferv2:
+		op.OpType = modules.WalletTransferOut
+		op.ToUser = d.ToUser
+		// agent top up: agent wallet pay the user, who must be touser if set
+		if d.FromAgent {
+			if op.ToUser != "" && op.ToUser != op.User {
+				return nil, ErrTransferTo
+			}
+			u, err := agent.WalletUser(op.Agent)
+			if err != nil {
+				return nil, err
+			}
+			op.User, op.ToUser = u, p.User
+		}
+		if op.ToUser == "" {
+			return nil, ErrTransferTo
+		}
+		// own wallets of two currency is exchange, not self transfer
+		if op.ToUser == op.User && (d.ToCurrency == "" || d.ToCurrency == op.Currency) {
+			return nil, ErrTransferSelf
+		}
+	case *modules.PostHoldv2:
+		op.OpType = modules.WalletHold
+		op.GameID = d.GameID
+		ttl := walletConfig.HoldTTL
+		if d.TTL != 0 {
+			ttl = time.Duration(d.TTL) * time.Second
+		}
+		if ttl <= 0 || ttl > walletConfig.HoldMaxTTL {
+			return nil, ErrHoldTTL
+		}
+		t := time.Now().Add(ttl)
+		op.ExpireAt = &t
+	case *modules.PostCapturev2:
+		op.OpType = modules.WalletCapture
+		op.HoldID = d.HoldID
+	case *modules.PostReleasev2:
+		op.OpType = modules.WalletRelease
+		op.HoldID = d.HoldID
+	default:
+		return nil, ErrInterface
+	}
+
+	if op.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	switch op.Amount.CheckCurrency(op.Currency) {
+	case nil:
+	case money.ErrCurrency:
+		return nil, ErrCurrency
+	default:
+		return nil, ErrAmountPrecision
+	}
+
+	if err := op.convert(p.Detail); err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// convert apply rate when detail ask for wallet or to currency other than
+// post currency. store and deduct then run on converted amount and keep the
+// post one in fx fields, transfer debit post amount and credit fx amount
+func (op *Operation) convert(detail interface{}) error {
+	target := ""
+	switch d := detail.(type) {
+	case *modules.PostStorev2:
+		target = d.WalletCurrency
+	case *modules.PostDeductv2:
+		target = d.WalletCurrency
+	case *modules.PostTransferv2:
+		target = d.ToCurrency
+	}
+	if target == "" || target == op.Currency {
+		return nil
+	}
+
+	amount, rate, err := fx.Convert(op.Amount, op.Currency, target)
+	switch err {
+	case nil:
+	case fx.ErrNoRate:
+		return ErrNoRate
+	case money.ErrCurrency:
+		return ErrCurrency
+	default:
+		return ErrInvalidAmount
+	}
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	op.Rate = rate
+	if op.OpType == modules.WalletTransferOut {
+		op.FXCurrency, op.FXAmount = target, amount
+		return nil
+	}
+
+	op.FXCurrency, op.FXAmount = op.Currency, op.Amount
+	op.Currency, op.Amount = target, amount
+
+	return nil
+}
+
+// Credit currency and amount to user of transfer get, differ from debit
+// side when converted
+func (op *Operation) Credit() (string, money.Amount) {
+	if op.OpType == modules.WalletTransferOut && op.Rate != 0 {
+		return op.FXCurrency, op.FXAmount
+	}
+	return op.Currency, op.Amount
+}
+
+// Delta signed amount apply to wallet
+func (op *Operation) Delta() money.Amount {
+	switch op.OpType {
+	case modules.WalletDeduct, modules.WalletTransferOut, modules.WalletHold:
+		return -op.Amount
+	}
+	return op.Amount
+}
+
+// Rollbackable only store and deduct can be reversed
+func Rollbackable(t modules.WalletOps) bool {
+	return t == modules.WalletStore || t == modules.WalletDeduct
+}
+
+// Process check player token and agent, then run post data on store.
+// repeat of a request id replay its kept outcome
+func Process(s Store, p *modules.PostDatav2) (*Operation, int, error) {
+	if err := session.Validate(p.Token, p.Agent, p.User); err != nil {
+		return nil, StatusOf(err), err
+	}
+
+	op, err := NewOperation(p)
+	if err != nil {
+		return nil, StatusOf(err), err
+	}
+	// fx currency is credit side of transfer, post side of store and deduct
+	if err := agent.Check(op.Agent, op.Amount, op.Currency, op.FXCurrency); err != nil {
+		return nil, StatusOf(err), err
+	}
+
+	switch op.OpType {
+	case modules.WalletRollback:
+		err = s.Rollback(op)
+	case modules.WalletTransferOut:
+		err = s.Transfer(op)
+	case modules.WalletHold:
+		err = s.Hold(op)
+	case modules.WalletCapture, modules.WalletRelease:
+		err = s.Settle(op)
+	default:
+		err = s.ApplyDelta(op)
+	}
+	if err == ErrRequestIDRepeat {
+		return replay(s, op)
+	}
+
+	status := StatusOf(err)
+	keepOutcome(s, op, status, err)
+
+	return op, status, err
+}
+
+// StatusOf http status for wallet error
+func StatusOf(err error) int {
+	return errcode.StatusOf(err)
+}
@@ -4,9 +4,7 @@ import (
 	"bytes"
 	"log"
 	"reflect"
-	"sync"
 
-	"github.com/kyos0109/test-wallet/modules"
 	kredis "github.com/kyos0109/test-wallet/redis"
 )
 
@@ -28,21 +26,3 @@ func BuildRedisDataWithDelimiter(args ...interface{}) string {
 	}
 	return buffer.String()[:len(buffer.String())-1]
 }
-
-// LogBalance to redis
-func LogBalance(rd *modules.RedisData) {
-	r := kredis.GetRedisClientInstance()
-	rd.OrderKey = BuildRedisDataWithDelimiter(kredis.RedisOrderPerfix, &rd.OrderID)
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		r.LPush(rd)
-	}()
-	go func() {
-		defer wg.Done()
-		r.SetOrderlog(rd)
-	}()
-	wg.Wait()
-}